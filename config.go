@@ -17,6 +17,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/mitchellh/mapstructure"
@@ -27,7 +28,10 @@ import (
 	"github.com/squat/generic-device-plugin/deviceplugin"
 )
 
-const defaultDomain = "squat.ai"
+const (
+	defaultDomain       = "squat.ai"
+	pluginDirectoryAuto = "auto"
+)
 
 // initConfig defines config flags, config file, and envs
 func initConfig() error {
@@ -49,9 +53,56 @@ For example, to permit allocation of the FUSE device 10 times: {"name": "fuse",
 Note: if omitted, "count" is assumed to be 1
 An "optional" field can be specified for individual paths to allow containers to start even when some devices are missing.
 For example, to expose serial devices that may or may not be present: {"name": "serial", "groups": [{"paths": [{"path": "/dev/ttyS0", "optional": true}, {"path": "/dev/ttyUSB0", "optional": true}]}]}
+Paths can declare correlation keys in braces, which match like "*" but join the matches of the paths in a group on the values of the keys instead of their sorted positions.
+For example, to expose every sound card with both a control and a capture device: {"name": "capture", "groups": [{"paths": [{"path": "/dev/snd/controlC{card}"}, {"path": "/dev/snd/pcmC{card}D0c"}]}]}
+A "match" mode can be specified for individual paths to match paths as "glob", "doublestar", where ** matches zero or more directories, or "regex"; if omitted, "match" is assumed to be "glob".
+An "exclude" list of patterns in the same match mode can be specified for individual paths to leave out some of the matches.
+For example, to expose all video nodes but the odd-numbered metadata nodes: {"name": "video", "groups": [{"paths": [{"path": "/dev/video[0-9]+", "match": "regex", "exclude": ["/dev/video[0-9]*[13579]"]}]}]}
 If mountPath is a directory, the device will be mounted to the directory with the name of the device.
-For example, to expose the serial devices to the /dev/serial directory: {"name": "serial", "groups": [{"paths": [{"path": "/dev/ttyUSB*", "mountPath": "/dev/serial/"}]}]}`)
-	flag.String("plugin-directory", v1beta1.DevicePluginPath, "The directory in which to create plugin sockets.")
+For example, to expose the serial devices to the /dev/serial directory: {"name": "serial", "groups": [{"paths": [{"path": "/dev/ttyUSB*", "mountPath": "/dev/serial/"}]}]}
+The mountPath is a Go template that can use .Path, .Target, .Base, .Captures, .Keys, .Index, and .Slot.
+For example, to expose serial devices by their number: {"name": "serial", "groups": [{"paths": [{"path": "/dev/ttyUSB([0-9]+)", "match": "regex", "mountPath": "/dev/serial/port{{index .Captures 1}}"}]}]}
+Instead of a path, a "device" selector can match device nodes by their "subsystem", "major" number, "minor" range, and kernel "drivers".
+For example, to expose every CDC ACM or FTDI serial device: {"name": "serial", "groups": [{"paths": [{"device": {"drivers": ["cdc_acm", "ftdi_sio"]}}]}]}
+A group can also select device nodes with "sysfs" specs by their sysfs "class" and "attributes" predicates, each of which checks an attribute with "equals", "glob", "regex", or "range".
+For example, to expose the capture nodes but not the metadata nodes of video devices: {"name": "video", "groups": [{"sysfs": [{"class": "video4linux", "attributes": [{"name": "index", "equals": "0"}]}]}]}
+A "type" can be specified for individual paths to check the type of the matched nodes and decide how they are mounted.
+Possible values are "Device", "CharDevice", "BlockDevice", "Mount", "Directory", "DirectoryOrCreate", "File", and "Socket"; if omitted, "type" is assumed to be "Device".
+For example, to expose a directory that is created if it does not exist: {"name": "cache", "groups": [{"paths": [{"path": "/var/cache/app", "mountPath": "/cache", "type": "DirectoryOrCreate"}]}]}
+Paths that are symbolic links, e.g. /dev/serial/by-id/*, are resolved to their real device node; every node is matched at most once per group and groups never yield two devices with the same nodes.
+A "keepLinks" field can be specified for individual paths to also expose the device in the container at the path of the link.
+For example: {"name": "ftdi", "groups": [{"paths": [{"path": "/dev/serial/by-id/usb-FTDI_*", "keepLinks": true}]}]}
+An "identity" can be specified for a group to keep device IDs stable when devices are replugged or renumbered.
+Possible values are "path", "serial", "port", and "udev"; if omitted, "identity" is assumed to be "path".
+An "idTemplate" can be specified for a group to use human-readable device IDs instead of hashes.
+For example, to identify serial devices by their USB serial number: {"name": "serial", "groups": [{"identity": "serial", "idTemplate": "{{.Serial}}", "paths": [{"path": "/dev/ttyUSB*"}]}]}
+An "onDiscoveryError" policy can be specified for a device to decide which devices are advertised when discovery fails.
+Possible values are "fail", "keep", and "partial"; if omitted, "onDiscoveryError" is assumed to be "fail".
+With "keep", an "unhealthyAfter" number of consecutive failures can be specified, after which the kept devices are marked unhealthy.
+For example, to keep advertising serial devices during transient errors: {"name": "serial", "onDiscoveryError": "keep", "unhealthyAfter": 3, "groups": [{"paths": [{"path": "/dev/ttyUSB*"}]}]}
+A "removalGracePeriod" can be specified for a device to keep advertising vanished devices as unhealthy for a while before removing them.
+An "appearAfter" number of consecutive scans can be specified for a device, after which new devices are advertised.
+For example, to ride out USB re-enumeration: {"name": "serial", "removalGracePeriod": "30s", "appearAfter": 2, "groups": [{"paths": [{"path": "/dev/ttyUSB*"}]}]}`)
+	flag.String("plugin-directory", v1beta1.DevicePluginPath, fmt.Sprintf(`The directory in which to create plugin sockets.
+If set to %q, the first of the following directories in which the kubelet is listening on its socket is used: %s.`, pluginDirectoryAuto, strings.Join(deviceplugin.KnownPluginDirs, ", ")))
+	flag.String("kubelet-socket", "", "The kubelet socket with which to register the plugins. Defaults to the kubelet.sock file in the plugin directory.")
+	flag.String("registration-mode", string(deviceplugin.RegisterRegistrationMode), fmt.Sprintf(`How to register the plugins with the kubelet.
+"register" calls the kubelet's registration service on the kubelet socket in the plugin directory.
+"plugin-watcher" creates the plugin sockets in the plugins registry directory, where the kubelet discovers them on its own, including after kubelet restarts.
+Possible values: %s`, availableRegistrationModes))
+	flag.String("plugins-registry-directory", deviceplugin.DefaultPluginsRegistryDir, `The directory watched by the kubelet's plugin watcher. Only used with --registration-mode=plugin-watcher.`)
+	flag.String("host-root", "/", `The directory at which the host's root file system is mounted, e.g. /host.
+All devices are discovered inside of this directory, while the paths given to the kubelet remain relative to the host's root.`)
+	flag.String("overlap-policy", string(deviceplugin.NoneOverlapPolicy), fmt.Sprintf(`The policy for deciding when devices of different resources overlap, i.e. refer to the same host device.
+Once a device is allocated, all overlapping devices of other resources are marked unhealthy until it is released.
+The policy applies to all resources unless a device sets its own "overlapPolicy"; two resources are compared according to the weaker of their policies.
+"exact" means devices overlap when they share a host path; "prefix" additionally means devices overlap when a host path of one is inside a directory host path of the other.
+Possible values: %s`, availableOverlapPolicies))
+	flag.String("pod-resources-socket", deviceplugin.DefaultPodResourcesSocket, "The kubelet pod resources API socket used to find out when overlapping devices are released.")
+	flag.Duration("drain-grace-period", 5*time.Second, `How long to report all devices as unhealthy to the kubelet on shutdown before stopping, so that no more Pods are scheduled onto them.
+A duration of 0 disables draining.`)
+	flag.Duration("liveness-threshold", 5*time.Minute, `How long a plugin may fail to register with the kubelet, e.g. while backing off, before the /livez endpoint fails, so that the container is restarted.
+A duration of 0 makes /livez always succeed.`)
 	flag.String("log-level", logLevelInfo, fmt.Sprintf("Log level to use. Possible values: %s", availableLogLevels))
 	flag.String("listen", ":8080", "The address at which to listen for health and metrics.")
 	flag.Bool("version", false, "Print version and exit")
@@ -108,6 +159,8 @@ func getConfiguredDevices() ([]*deviceplugin.DeviceSpec, error) {
 				TagName: "json",
 				DecodeHook: mapstructure.ComposeDecodeHookFunc(
 					deviceplugin.ToUSBIDHookFunc,
+					deviceplugin.ToDurationHookFunc,
+					deviceplugin.ToAttributeValueHookFunc,
 				),
 			})
 			if err != nil {
@@ -0,0 +1,76 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetConfiguredDevices(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		device interface{}
+	}{
+		{
+			name: "flag",
+			device: []string{`name: video
+groups:
+  - sysfs:
+      - class: video4linux
+        attributes:
+          - name: index
+            equals: 0
+`},
+		},
+		{
+			name: "config file",
+			device: []interface{}{
+				map[string]interface{}{
+					"name": "video",
+					"groups": []interface{}{
+						map[string]interface{}{
+							"sysfs": []interface{}{
+								map[string]interface{}{
+									"class": "video4linux",
+									"attributes": []interface{}{
+										map[string]interface{}{"name": "index", "equals": 0},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			viper.Set("device", tc.device)
+			defer viper.Set("device", nil)
+			deviceSpecs, err := getConfiguredDevices()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(deviceSpecs) != 1 || len(deviceSpecs[0].Groups) != 1 || len(deviceSpecs[0].Groups[0].Sysfs) != 1 {
+				t.Fatalf("expected 1 device with 1 sysfs spec; got %+v", deviceSpecs)
+			}
+			attributes := deviceSpecs[0].Groups[0].Sysfs[0].Attributes
+			if len(attributes) != 1 || attributes[0].Equals == nil || *attributes[0].Equals != "0" {
+				t.Errorf("expected index to equal %q; got %+v", "0", attributes)
+			}
+		})
+	}
+}
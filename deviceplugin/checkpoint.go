@@ -0,0 +1,201 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log/level"
+)
+
+// KubeletCheckpoint is the name of the file in the device plugin directory
+// in which the kubelet records the devices allocated to containers.
+const KubeletCheckpoint = "kubelet_internal_checkpoint"
+
+// checkpoint is the subset of the kubelet's device manager checkpoint that the plugin needs.
+type checkpoint struct {
+	Data struct {
+		PodDeviceEntries []struct {
+			PodUID        string
+			ContainerName string
+			ResourceName  string
+			DeviceIDs     checkpointDeviceIDs
+		}
+	}
+}
+
+// checkpointDeviceIDs is the list of device IDs allocated to a container.
+// Kubelets before v1.20 write a plain list, while newer ones group the IDs by NUMA node.
+type checkpointDeviceIDs []string
+
+// UnmarshalJSON handles both formats of device IDs.
+func (ids *checkpointDeviceIDs) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var list []string
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*ids = list
+		return nil
+	}
+	var byNode map[string][]string
+	if err := json.Unmarshal(data, &byNode); err != nil {
+		return err
+	}
+	*ids = nil
+	for _, list := range byNode {
+		*ids = append(*ids, list...)
+	}
+	return nil
+}
+
+// parseCheckpoint returns the set of allocated device IDs for each resource.
+func parseCheckpoint(data []byte) (map[string]map[string]struct{}, error) {
+	var c checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse kubelet checkpoint: %w", err)
+	}
+	allocated := make(map[string]map[string]struct{})
+	for _, e := range c.Data.PodDeviceEntries {
+		if allocated[e.ResourceName] == nil {
+			allocated[e.ResourceName] = make(map[string]struct{})
+		}
+		for _, id := range e.DeviceIDs {
+			allocated[e.ResourceName][id] = struct{}{}
+		}
+	}
+	return allocated, nil
+}
+
+// WatchCheckpoint records the devices that the kubelet checkpoint at the given path
+// reports as allocated, at first and whenever the checkpoint changes,
+// until the given context is cancelled.
+// Checkpointed devices are treated like devices that the kubelet reports as in use
+// until the kubelet first reports the devices in use, e.g. while the plugin starts.
+func (r *ClaimRegistry) WatchCheckpoint(ctx context.Context, path string) error {
+	var events chan fsnotify.Event
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = w.Add(filepath.Dir(path)); err != nil {
+			_ = w.Close()
+		}
+	}
+	// Poll when file-system notifications are unavailable.
+	var ticks <-chan time.Time
+	if err != nil {
+		_ = level.Warn(r.logger).Log("msg", "falling back to polling the kubelet checkpoint", "err", err)
+		t := time.NewTicker(claimSyncInterval)
+		defer t.Stop()
+		ticks = t.C
+	} else {
+		defer func() { _ = w.Close() }()
+		events = w.Events
+	}
+	for {
+		if err := r.readCheckpoint(path); err != nil {
+			_ = level.Warn(r.logger).Log("msg", "failed to read kubelet checkpoint", "err", err)
+		}
+	Wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticks:
+				break Wait
+			case e := <-events:
+				if e.Name == path && (e.Has(fsnotify.Create) || e.Has(fsnotify.Write)) {
+					break Wait
+				}
+			}
+		}
+	}
+}
+
+// readCheckpoint replaces the checkpointed devices with those in the checkpoint at the given path.
+// A missing checkpoint means that no devices are allocated.
+func (r *ClaimRegistry) readCheckpoint(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	allocated := make(map[string]map[string]struct{})
+	if err == nil {
+		if allocated, err = parseCheckpoint(data); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpointed = allocated
+	return nil
+}
+
+// allocated returns the IDs of the devices of the given resource
+// that the kubelet checkpoint reports as allocated.
+// Once the kubelet has reported the devices in use, released devices that remain in the checkpoint are left out.
+// It is safe to call on a nil ClaimRegistry.
+func (r *ClaimRegistry) allocated(resource string) map[string]struct{} {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make(map[string]struct{}, len(r.checkpointed[resource]))
+	for id := range r.checkpointed[resource] {
+		if r.synced && !r.claims[resource][id].confirmed {
+			continue
+		}
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// reconcile warns about devices that the kubelet checkpoint reports as allocated
+// but that are no longer among the given discovered devices.
+// Every device is only reported once until it is discovered again.
+func (gp *GenericPlugin) reconcile(devices []device) {
+	allocated := gp.claims.allocated(gp.ds.Name)
+	discovered := make(map[string]struct{}, len(devices))
+	for _, d := range devices {
+		discovered[d.ID] = struct{}{}
+	}
+	for id := range allocated {
+		if _, ok := discovered[id]; ok {
+			continue
+		}
+		if _, ok := gp.orphans[id]; ok {
+			continue
+		}
+		gp.orphans[id] = struct{}{}
+		_ = level.Warn(gp.logger).Log("msg", "allocated device is no longer discovered", "id", id)
+	}
+	for id := range gp.orphans {
+		if _, ok := allocated[id]; !ok {
+			delete(gp.orphans, id)
+			continue
+		}
+		if _, ok := discovered[id]; ok {
+			delete(gp.orphans, id)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+func TestParseCheckpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file string
+		out  map[string]map[string]struct{}
+	}{
+		{
+			name: "NUMA nodes",
+			file: "kubelet_internal_checkpoint",
+			out: map[string]map[string]struct{}{
+				"squat.ai/video":  {"a5d0c6e9b1f2": {}},
+				"squat.ai/serial": {"3c1f0e2a9d84": {}, "7be25c0d41a3": {}},
+				"nvidia.com/gpu":  {"GPU-1234": {}},
+			},
+		},
+		{
+			name: "list",
+			file: "kubelet_internal_checkpoint_v1.19",
+			out: map[string]map[string]struct{}{
+				"squat.ai/video":  {"a5d0c6e9b1f2": {}},
+				"squat.ai/serial": {"3c1f0e2a9d84": {}, "7be25c0d41a3": {}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := parseCheckpoint(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(out, tc.out) {
+				t.Errorf("expected %v; got %v", tc.out, out)
+			}
+		})
+	}
+
+	if _, err := parseCheckpoint([]byte(`{"Data":{"PodDeviceEntries":[{"DeviceIDs":"a5d0c6e9b1f2"}]}}`)); err == nil {
+		t.Error("expected an error for malformed device IDs")
+	}
+}
+
+func TestCheckpointClaims(t *testing.T) {
+	r := NewClaimRegistry(PrefixOverlapPolicy, "", nil)
+	r.update("squat.ai/audio", map[string][]string{"audio": {"/dev/snd"}})
+	r.update("squat.ai/capture", map[string][]string{"capture": {"/dev/snd/pcmC0D0c"}})
+
+	path := filepath.Join(t.TempDir(), KubeletCheckpoint)
+	if err := r.readCheckpoint(path); err != nil {
+		t.Fatalf("unexpected error for missing checkpoint: %v", err)
+	}
+	if r.conflicts("squat.ai/capture", []string{"/dev/snd/pcmC0D0c"}) {
+		t.Error("expected no conflict without checkpoint")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"Data":{"PodDeviceEntries":[{"ResourceName":"squat.ai/audio","DeviceIDs":{"-1":["audio"]}}]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.readCheckpoint(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !r.conflicts("squat.ai/capture", []string{"/dev/snd/pcmC0D0c"}) {
+		t.Error("expected checkpointed device to conflict")
+	}
+	if err := r.claim("squat.ai/capture", "capture"); err == nil {
+		t.Error("expected claim to fail")
+	}
+	if ids := r.allocated("squat.ai/audio"); !reflect.DeepEqual(ids, map[string]struct{}{"audio": {}}) {
+		t.Errorf("expected audio to be allocated; got %v", ids)
+	}
+
+	// The device is released but the kubelet does not rewrite the checkpoint.
+	r.sync(map[string]map[string]struct{}{})
+	if err := r.readCheckpoint(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.conflicts("squat.ai/capture", []string{"/dev/snd/pcmC0D0c"}) {
+		t.Error("expected released device not to conflict")
+	}
+	if err := r.claim("squat.ai/capture", "capture"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ids := r.allocated("squat.ai/audio"); len(ids) != 0 {
+		t.Errorf("expected no allocated devices; got %v", ids)
+	}
+}
+
+func TestWatchCheckpoint(t *testing.T) {
+	r := NewClaimRegistry(NoneOverlapPolicy, "", nil)
+	path := filepath.Join(t.TempDir(), KubeletCheckpoint)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.WatchCheckpoint(ctx, path)
+	}()
+
+	// Write the checkpoint atomically like the kubelet does.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(`{"Data":{"PodDeviceEntries":[{"ResourceName":"squat.ai/fuse","DeviceIDs":{"-1":["fuse"]}}]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); len(r.allocated("squat.ai/fuse")) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out waiting for checkpoint")
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetPreferredAllocation(t *testing.T) {
+	fsys := fstest.MapFS{"dev/ttyUSB0": charDevice, "dev/ttyUSB1": charDevice}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "serial",
+		Groups: []*Group{{Count: 2, Paths: []*Path{{Path: "/dev/ttyUSB*"}}}},
+	}, fsys)
+	gp.claims = NewClaimRegistry(NoneOverlapPolicy, "", nil)
+	if _, err := gp.refreshDevices(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	byPath := make(map[string][]string)
+	var all []string
+	for id, d := range gp.devices {
+		byPath[d.hostPaths()[0]] = append(byPath[d.hostPaths()[0]], id)
+		all = append(all, id)
+	}
+	usb0, usb1 := byPath["/dev/ttyUSB0"], byPath["/dev/ttyUSB1"]
+
+	// One copy of /dev/ttyUSB0 is allocated, so the other copy is least preferred.
+	gp.claims.checkpointed = map[string]map[string]struct{}{gp.ds.Name: {usb0[0]: {}}}
+	res, err := gp.GetPreferredAllocation(context.Background(), &v1beta1.PreferredAllocationRequest{
+		ContainerRequests: []*v1beta1.ContainerPreferredAllocationRequest{
+			{AvailableDeviceIDs: []string{usb0[1], usb1[0], usb1[1]}, AllocationSize: 1},
+			{AvailableDeviceIDs: []string{usb0[1], usb1[0]}, AllocationSize: 2},
+			{AvailableDeviceIDs: []string{usb0[1], usb1[0], usb1[1]}, MustIncludeDeviceIDs: []string{usb0[1]}, AllocationSize: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pathsOf := func(ids []string) []string {
+		var paths []string
+		for _, id := range ids {
+			paths = append(paths, gp.devices[id].hostPaths()...)
+		}
+		return paths
+	}
+	for i, expected := range [][]string{
+		{"/dev/ttyUSB1"},
+		{"/dev/ttyUSB1", "/dev/ttyUSB0"},
+		{"/dev/ttyUSB0", "/dev/ttyUSB1"},
+	} {
+		if paths := pathsOf(res.ContainerResponses[i].DeviceIDs); !reflect.DeepEqual(paths, expected) {
+			t.Errorf("request %d: expected %v; got %v", i, expected, paths)
+		}
+	}
+	if len(all) != 4 {
+		t.Errorf("expected 4 devices; got %d", len(all))
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	podresourcesv1 "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	// DefaultPodResourcesSocket is the default location of the kubelet's pod resources API.
+	DefaultPodResourcesSocket = "/var/lib/kubelet/pod-resources/kubelet.sock"
+	claimSyncInterval         = 5 * time.Second
+	// claimTTL is how long a claim made by Allocate is honored
+	// before the kubelet reports the device as being in use.
+	claimTTL = 1 * time.Minute
+)
+
+// OverlapPolicy describes when devices of two different resources are considered
+// to be the same physical device and thus cannot be allocated at the same time.
+type OverlapPolicy string
+
+const (
+	// NoneOverlapPolicy disables cross-resource exclusivity.
+	NoneOverlapPolicy OverlapPolicy = "none"
+	// ExactOverlapPolicy considers two devices to overlap when they share a host path.
+	ExactOverlapPolicy OverlapPolicy = "exact"
+	// PrefixOverlapPolicy considers two devices to overlap when they share a host path
+	// or when a host path of one device is contained in a directory host path of the other,
+	// e.g. /dev/snd and /dev/snd/pcmC0D0c.
+	PrefixOverlapPolicy OverlapPolicy = "prefix"
+)
+
+// OverlapPolicies is the list of all supported overlap policies, from the weakest to the strictest.
+var OverlapPolicies = []OverlapPolicy{NoneOverlapPolicy, ExactOverlapPolicy, PrefixOverlapPolicy}
+
+// weaker returns the weaker of the two given overlap policies.
+func weaker(a, b OverlapPolicy) OverlapPolicy {
+	if slices.Index(OverlapPolicies, a) < slices.Index(OverlapPolicies, b) {
+		return a
+	}
+	return b
+}
+
+// claim represents the allocation of a device.
+type claim struct {
+	// confirmed is true when the kubelet reported the device as in use.
+	confirmed bool
+	// expires is when an unconfirmed claim is dropped.
+	expires time.Time
+}
+
+// ClaimRegistry tracks which devices are allocated across all of the
+// resources served by a process, so that a device allocated under one resource
+// can be marked unhealthy in every other resource that overlaps with it.
+// The overlap policy applies to all resources unless a resource overrides it;
+// two resources are compared according to the weaker of their policies.
+// A ClaimRegistry is safe for concurrent use.
+type ClaimRegistry struct {
+	policy OverlapPolicy
+	socket string
+	logger log.Logger
+
+	mu sync.Mutex
+	// policies holds the overlap policies of the resources that override the policy of the registry.
+	policies map[string]OverlapPolicy
+	// devices holds the host paths of every device of every resource.
+	devices map[string]map[string][]string
+	// claims holds the allocated device IDs of every resource.
+	claims map[string]map[string]claim
+	// checkpointed holds the device IDs of every resource that the kubelet checkpoint reports as allocated.
+	checkpointed map[string]map[string]struct{}
+	// synced is true once the kubelet has reported the devices in use.
+	// The kubelet only rewrites its checkpoint on allocation, so the checkpoint keeps listing released devices
+	// and is only trusted until then.
+	synced bool
+	// now allows us to control time for testing.
+	now func() time.Time
+}
+
+// NewClaimRegistry creates a new ClaimRegistry that enforces the given overlap policy.
+// Allocations are released when the kubelet's pod resources API at the given socket
+// no longer reports them as being in use.
+func NewClaimRegistry(policy OverlapPolicy, socket string, logger log.Logger) *ClaimRegistry {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &ClaimRegistry{
+		policy:   policy,
+		socket:   socket,
+		logger:   logger,
+		policies: make(map[string]OverlapPolicy),
+		devices:  make(map[string]map[string][]string),
+		claims:   make(map[string]map[string]claim),
+		now:      time.Now,
+	}
+}
+
+// override makes the given resource use the given overlap policy instead of the policy of the registry.
+// It must be called before the registry runs.
+func (r *ClaimRegistry) override(resource string, policy OverlapPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies[resource] = policy
+}
+
+// policyOf returns the overlap policy of the given resource.
+// The caller must hold the lock.
+func (r *ClaimRegistry) policyOf(resource string) OverlapPolicy {
+	if p, ok := r.policies[resource]; ok {
+		return p
+	}
+	if r.policy == "" {
+		return NoneOverlapPolicy
+	}
+	return r.policy
+}
+
+// enabled returns true if the registry enforces exclusivity for any resource.
+// It is safe to call on a nil ClaimRegistry.
+func (r *ClaimRegistry) enabled() bool {
+	if r == nil {
+		return false
+	}
+	if r.policy != "" && r.policy != NoneOverlapPolicy {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.policies {
+		if p != NoneOverlapPolicy {
+			return true
+		}
+	}
+	return false
+}
+
+// update records the host paths of all of the devices of the given resource.
+func (r *ClaimRegistry) update(resource string, devices map[string][]string) {
+	if !r.enabled() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[resource] = devices
+}
+
+// conflicts returns true if the given host paths overlap with a device
+// that is allocated under any resource other than the given one.
+func (r *ClaimRegistry) conflicts(resource string, paths []string) bool {
+	if !r.enabled() {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.conflict(resource, paths)
+	return ok
+}
+
+// conflict returns the first claimed device of another resource that overlaps with the given paths.
+// The caller must hold the lock.
+func (r *ClaimRegistry) conflict(resource string, paths []string) (string, bool) {
+	now := r.now()
+	for res, claims := range r.claims {
+		policy := weaker(r.policyOf(resource), r.policyOf(res))
+		if res == resource || policy == NoneOverlapPolicy {
+			continue
+		}
+		for id, c := range claims {
+			if !c.confirmed && now.After(c.expires) {
+				continue
+			}
+			if overlaps(policy, paths, r.devices[res][id]) {
+				return fmt.Sprintf("%s/%s", res, id), true
+			}
+		}
+	}
+	for res, ids := range r.checkpointed {
+		policy := weaker(r.policyOf(resource), r.policyOf(res))
+		if r.synced || res == resource || policy == NoneOverlapPolicy {
+			continue
+		}
+		for id := range ids {
+			if overlaps(policy, paths, r.devices[res][id]) {
+				return fmt.Sprintf("%s/%s", res, id), true
+			}
+		}
+	}
+	return "", false
+}
+
+// claim marks the given devices of the given resource as allocated.
+// It returns an error and claims none of the devices if any of them overlaps
+// with a device that is already allocated under another resource.
+func (r *ClaimRegistry) claim(resource string, ids ...string) error {
+	if !r.enabled() {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if other, ok := r.conflict(resource, r.devices[resource][id]); ok {
+			return fmt.Errorf("device %q overlaps with allocated device %q", id, other)
+		}
+	}
+	if r.claims[resource] == nil {
+		r.claims[resource] = make(map[string]claim)
+	}
+	for _, id := range ids {
+		if c, ok := r.claims[resource][id]; ok && c.confirmed {
+			continue
+		}
+		r.claims[resource][id] = claim{expires: r.now().Add(claimTTL)}
+	}
+	return nil
+}
+
+// sync replaces the confirmed claims with the given set of in-use devices.
+// Unconfirmed claims are kept until they expire.
+func (r *ClaimRegistry) sync(inUse map[string]map[string]struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	claims := make(map[string]map[string]claim)
+	for res, ids := range inUse {
+		if _, ok := r.devices[res]; !ok {
+			continue
+		}
+		claims[res] = make(map[string]claim, len(ids))
+		for id := range ids {
+			claims[res][id] = claim{confirmed: true}
+		}
+	}
+	for res, cs := range r.claims {
+		for id, c := range cs {
+			if c.confirmed || now.After(c.expires) {
+				continue
+			}
+			if _, ok := claims[res][id]; ok {
+				continue
+			}
+			if claims[res] == nil {
+				claims[res] = make(map[string]claim)
+			}
+			claims[res][id] = c
+		}
+	}
+	r.claims = claims
+	r.synced = true
+}
+
+// Run periodically synchronizes the registry with the devices that the kubelet
+// reports as in use until the given context is cancelled.
+func (r *ClaimRegistry) Run(ctx context.Context) error {
+	if !r.enabled() || r.socket == "" {
+		<-ctx.Done()
+		return nil
+	}
+	t := time.NewTicker(claimSyncInterval)
+	defer t.Stop()
+	for {
+		if err := r.syncWithKubelet(ctx); err != nil {
+			_ = level.Warn(r.logger).Log("msg", "failed to list devices in use", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (r *ClaimRegistry) syncWithKubelet(ctx context.Context) error {
+	conn, err := grpc.NewClient("unix://"+r.socket, grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", r.socket)
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to connect to kubelet pod resources API: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx, cancel := context.WithTimeout(ctx, claimSyncInterval)
+	defer cancel()
+	res, err := podresourcesv1.NewPodResourcesListerClient(conn).List(ctx, &podresourcesv1.ListPodResourcesRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to list pod resources: %v", err)
+	}
+	r.sync(inUseDevices(res))
+	return nil
+}
+
+// inUseDevices returns the set of device IDs in use for each resource.
+func inUseDevices(res *podresourcesv1.ListPodResourcesResponse) map[string]map[string]struct{} {
+	inUse := make(map[string]map[string]struct{})
+	for _, p := range res.GetPodResources() {
+		for _, c := range p.GetContainers() {
+			for _, d := range c.GetDevices() {
+				if inUse[d.GetResourceName()] == nil {
+					inUse[d.GetResourceName()] = make(map[string]struct{})
+				}
+				for _, id := range d.GetDeviceIds() {
+					inUse[d.GetResourceName()][id] = struct{}{}
+				}
+			}
+		}
+	}
+	return inUse
+}
+
+// overlaps returns true if any of the paths in a overlaps with any of the paths in b
+// according to the given policy.
+func overlaps(policy OverlapPolicy, a, b []string) bool {
+	for _, pa := range a {
+		for _, pb := range b {
+			if pa == pb {
+				return true
+			}
+			if policy == PrefixOverlapPolicy && (contains(pa, pb) || contains(pb, pa)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains returns true if path is located inside of dir.
+func contains(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, "../")
+}
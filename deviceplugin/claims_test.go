@@ -0,0 +1,193 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+func TestOverlaps(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy OverlapPolicy
+		a      []string
+		b      []string
+		out    bool
+	}{
+		{
+			name:   "same path exact",
+			policy: ExactOverlapPolicy,
+			a:      []string{"/dev/snd/pcmC0D0c"},
+			b:      []string{"/dev/snd/controlC0", "/dev/snd/pcmC0D0c"},
+			out:    true,
+		},
+		{
+			name:   "directory exact",
+			policy: ExactOverlapPolicy,
+			a:      []string{"/dev/snd"},
+			b:      []string{"/dev/snd/pcmC0D0c"},
+			out:    false,
+		},
+		{
+			name:   "directory prefix",
+			policy: PrefixOverlapPolicy,
+			a:      []string{"/dev/snd"},
+			b:      []string{"/dev/snd/pcmC0D0c"},
+			out:    true,
+		},
+		{
+			name:   "sibling prefix",
+			policy: PrefixOverlapPolicy,
+			a:      []string{"/dev/snd"},
+			b:      []string{"/dev/sndstat"},
+			out:    false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if out := overlaps(tc.policy, tc.a, tc.b); out != tc.out {
+				t.Errorf("expected %t; got %t", tc.out, out)
+			}
+			if out := overlaps(tc.policy, tc.b, tc.a); out != tc.out {
+				t.Errorf("expected %t in reverse; got %t", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestClaimRegistry(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewClaimRegistry(PrefixOverlapPolicy, "", nil)
+	r.now = func() time.Time { return now }
+	r.update("audio", map[string][]string{"a": {"/dev/snd"}})
+	r.update("capture", map[string][]string{"c": {"/dev/snd/controlC0", "/dev/snd/pcmC0D0c"}})
+
+	if r.conflicts("audio", []string{"/dev/snd"}) {
+		t.Fatal("expected no conflict before any claim")
+	}
+	if err := r.claim("capture", "c"); err != nil {
+		t.Fatalf("expected claim to succeed; got %v", err)
+	}
+	if !r.conflicts("audio", []string{"/dev/snd"}) {
+		t.Error("expected audio device to conflict with claimed capture device")
+	}
+	if r.conflicts("capture", []string{"/dev/snd/controlC0", "/dev/snd/pcmC0D0c"}) {
+		t.Error("expected a resource not to conflict with itself")
+	}
+	if err := r.claim("audio", "a"); err == nil {
+		t.Error("expected claim of overlapping device to fail")
+	}
+
+	// Unconfirmed claims survive a sync until they expire.
+	r.sync(nil)
+	if !r.conflicts("audio", []string{"/dev/snd"}) {
+		t.Error("expected unconfirmed claim to be kept")
+	}
+	now = now.Add(claimTTL + time.Second)
+	if r.conflicts("audio", []string{"/dev/snd"}) {
+		t.Error("expected unconfirmed claim to expire")
+	}
+
+	// Confirmed claims last until the kubelet stops reporting them.
+	r.sync(map[string]map[string]struct{}{"capture": {"c": {}}})
+	now = now.Add(claimTTL + time.Second)
+	if !r.conflicts("audio", []string{"/dev/snd"}) {
+		t.Error("expected confirmed claim to be kept")
+	}
+	r.sync(map[string]map[string]struct{}{})
+	if r.conflicts("audio", []string{"/dev/snd"}) {
+		t.Error("expected released claim to be removed")
+	}
+}
+
+func TestClaimRegistryOverride(t *testing.T) {
+	r := NewClaimRegistry(PrefixOverlapPolicy, "", nil)
+	r.update("audio", map[string][]string{"a": {"/dev/snd"}})
+	r.update("capture", map[string][]string{"c": {"/dev/snd/pcmC0D0c"}})
+	r.update("playback", map[string][]string{"p": {"/dev/snd/pcmC0D0p"}})
+	if err := r.claim("audio", "a"); err != nil {
+		t.Fatalf("expected claim to succeed; got %v", err)
+	}
+	if !r.conflicts("capture", []string{"/dev/snd/pcmC0D0c"}) {
+		t.Error("expected capture device to conflict with claimed audio device")
+	}
+
+	// The weaker policy of two resources decides whether their devices overlap.
+	r.override("capture", ExactOverlapPolicy)
+	r.override("playback", NoneOverlapPolicy)
+	for _, res := range []string{"capture", "playback"} {
+		if r.conflicts(res, r.devices[res][res[:1]]) {
+			t.Errorf("expected %s device not to conflict with claimed audio device", res)
+		}
+	}
+
+	r = NewClaimRegistry(NoneOverlapPolicy, "", nil)
+	if r.enabled() {
+		t.Error("expected registry without overlap policies to be disabled")
+	}
+	r.override("audio", PrefixOverlapPolicy)
+	if !r.enabled() {
+		t.Error("expected registry with an overriding overlap policy to be enabled")
+	}
+}
+
+func TestAllocateClaimsAllOrNothing(t *testing.T) {
+	fsys := fstest.MapFS{"dev/snd/controlC0": charDevice, "dev/snd/pcmC0D0c": charDevice}
+	claims := NewClaimRegistry(PrefixOverlapPolicy, "", nil)
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "capture",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/snd/*"}}}},
+	}, fsys)
+	gp.claims = claims
+	if _, err := gp.refreshDevices(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for id := range gp.devices {
+		ids = append(ids, id)
+	}
+	claims.update("audio", map[string][]string{"a": {"/dev/snd"}})
+
+	// The second container requests a device that does not exist, so nothing may stay claimed.
+	_, err := gp.Allocate(context.Background(), &v1beta1.AllocateRequest{ContainerRequests: []*v1beta1.ContainerAllocateRequest{
+		{DevicesIds: ids},
+		{DevicesIds: []string{"missing"}},
+	}})
+	if err == nil {
+		t.Fatal("expected allocation of a missing device to fail")
+	}
+	if claims.conflicts("audio", []string{"/dev/snd"}) {
+		t.Error("expected no claims to be left after a failed allocation")
+	}
+
+	// A conflicting device fails the allocation without claiming the others.
+	claims.update("serial", map[string][]string{"s": {"/dev/snd/pcmC0D0c"}})
+	if err := claims.claim("serial", "s"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := gp.Allocate(context.Background(), &v1beta1.AllocateRequest{ContainerRequests: []*v1beta1.ContainerAllocateRequest{{DevicesIds: ids}}}); err == nil {
+		t.Fatal("expected allocation of a conflicting device to fail")
+	}
+	claims.mu.Lock()
+	claimed := len(claims.claims["capture"])
+	claims.mu.Unlock()
+	if claimed != 0 {
+		t.Errorf("expected no claims for capture; got %d", claimed)
+	}
+}
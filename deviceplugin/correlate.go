@@ -0,0 +1,212 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// keyRegexp matches the correlation keys in a path, e.g. {card} in /dev/snd/controlC{card}.
+var keyRegexp = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// pattern is a path whose correlation keys were parsed.
+type pattern struct {
+	// search matches the same paths as the pattern in its match mode, with every key replaced by a wildcard.
+	search string
+	// keys are the names of the correlation keys in the order in which they appear.
+	keys []string
+	// re matches the same paths as the pattern and captures the values of its wildcards and keys.
+	re *regexp.Regexp
+}
+
+// parsePattern parses the correlation keys of the given path in the given match mode.
+// In glob and doublestar mode, a key in braces matches one or more characters other than a slash.
+// In regex mode, the named groups of the expression are the keys.
+func parsePattern(mode MatchMode, path string) (*pattern, error) {
+	p := &pattern{search: path}
+	if mode == RegexMatchMode {
+		re, err := compileRegexp(path)
+		if err != nil {
+			return nil, err
+		}
+		p.re = re
+	} else {
+		p.search = keyRegexp.ReplaceAllString(path, "*")
+		var b strings.Builder
+		b.WriteString("^")
+		var last int
+		for _, loc := range keyRegexp.FindAllStringSubmatchIndex(path, -1) {
+			if err := globToRegexp(&b, path[last:loc[0]], mode == DoublestarMatchMode, last == 0 || path[last-1] == '/'); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "(?P<%s>[^/]+)", path[loc[2]:loc[3]])
+			last = loc[1]
+		}
+		if err := globToRegexp(&b, path[last:], mode == DoublestarMatchMode, last == 0 || path[last-1] == '/'); err != nil {
+			return nil, err
+		}
+		b.WriteString("$")
+		re, err := regexp.Compile(b.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+		}
+		p.re = re
+	}
+	for _, name := range p.re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if slices.Contains(p.keys, name) {
+			return nil, fmt.Errorf("key %q is used more than once in %q", name, path)
+		}
+		p.keys = append(p.keys, name)
+	}
+	return p, nil
+}
+
+// globToRegexp writes a regular expression matching the same paths as the given glob,
+// in which every wildcard is a capturing group.
+// The glob uses the syntax of path.Match and, if doublestar is true, ** segments
+// match zero or more directories. segmentStart reports whether the glob starts a segment.
+func globToRegexp(b *strings.Builder, glob string, doublestar, segmentStart bool) error {
+	for i := 0; i < len(glob); i++ {
+		if doublestar && strings.HasPrefix(glob[i:], "**") && ((i == 0 && segmentStart) || (i > 0 && glob[i-1] == '/')) {
+			switch {
+			case i+2 == len(glob):
+				b.WriteString("([^/]+(?:/[^/]+)*)")
+				i++
+				continue
+			case glob[i+2] == '/':
+				b.WriteString("((?:[^/]+/)*)")
+				i += 2
+				continue
+			}
+		}
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString("([^/]*)")
+		case '?':
+			b.WriteString("([^/])")
+		case '\\':
+			if i++; i == len(glob) {
+				return fmt.Errorf("malformed glob %q: trailing backslash", glob)
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			b.WriteString("([")
+			if i+1 < len(glob) && glob[i+1] == '^' {
+				b.WriteString("^")
+				i++
+			}
+			for i++; ; i++ {
+				if i == len(glob) {
+					return fmt.Errorf("malformed glob %q: unterminated character class", glob)
+				}
+				if glob[i] == ']' {
+					break
+				}
+				if glob[i] == '\\' {
+					if i++; i == len(glob) {
+						return fmt.Errorf("malformed glob %q: trailing backslash", glob)
+					}
+					b.WriteString(`\`)
+				}
+				b.WriteByte(glob[i])
+			}
+			b.WriteString("])")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return nil
+}
+
+// captures returns the values captured from the given path by the wildcards and keys of the pattern,
+// preceded by the path itself, and the values of the keys by their names.
+func (p *pattern) captures(path string) ([]string, map[string]string, bool) {
+	m := p.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, nil, false
+	}
+	var keys map[string]string
+	if len(p.keys) > 0 {
+		keys = make(map[string]string, len(p.keys))
+		for _, k := range p.keys {
+			keys[k] = m[p.re.SubexpIndex(k)]
+		}
+	}
+	return m, keys, true
+}
+
+// keyOf returns the values of the given correlation keys ordered by the names of the keys,
+// so that the keys of paths that use the same keys in different orders can be compared.
+func keyOf(values map[string]string) []string {
+	key := make([]string, 0, len(values))
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		key = append(key, values[k])
+	}
+	return key
+}
+
+// compareKeys orders the values of correlation keys naturally, i.e. numbers by their value.
+func compareKeys(a, b []string) int {
+	for i := range min(len(a), len(b)) {
+		x, errX := strconv.Atoi(a[i])
+		y, errY := strconv.Atoi(b[i])
+		var c int
+		if errX == nil && errY == nil {
+			c = cmp.Compare(x, y)
+		} else {
+			c = cmp.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// validateKeys checks that all of the paths of the group that use correlation keys use the same keys.
+func (g *Group) validateKeys() error {
+	var keys []string
+	var first string
+	for _, p := range g.Paths {
+		pt, err := parsePattern(p.Match, p.Path)
+		if err != nil {
+			return err
+		}
+		if len(pt.keys) == 0 {
+			continue
+		}
+		if p.Limit > 1 {
+			return fmt.Errorf("path %q with correlation keys cannot have a limit", p.Path)
+		}
+		sorted := slices.Sorted(slices.Values(pt.keys))
+		if keys == nil {
+			keys, first = sorted, p.Path
+			continue
+		}
+		if !slices.Equal(keys, sorted) {
+			return fmt.Errorf("paths %q and %q must use the same correlation keys", first, p.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mode MatchMode
+		path string
+		glob string
+		// matches maps paths to the values of their keys, or nil if they do not match.
+		matches map[string][]string
+		err     bool
+	}{
+		{
+			name: "no keys",
+			path: "/dev/ttyUSB*",
+			glob: "/dev/ttyUSB*",
+		},
+		{
+			name: "one key",
+			path: "/dev/snd/controlC{card}",
+			glob: "/dev/snd/controlC*",
+			matches: map[string][]string{
+				"/dev/snd/controlC0":  {"0"},
+				"/dev/snd/controlC10": {"10"},
+				"/dev/snd/controlC":   nil,
+				"/dev/snd/controlC0/": nil,
+			},
+		},
+		{
+			name: "keys are ordered by name",
+			path: "/dev/snd/pcmC{card}D{device}?",
+			glob: "/dev/snd/pcmC*D*?",
+			matches: map[string][]string{
+				"/dev/snd/pcmC1D2c": {"1", "2"},
+				"/dev/snd/pcmC1D2":  nil,
+			},
+		},
+		{
+			name: "globs around keys",
+			path: "/dev/[a-c]*/{name}.\\*",
+			glob: "/dev/[a-c]*/*.\\*",
+			matches: map[string][]string{
+				"/dev/bus/usb.*": {"usb"},
+				"/dev/bus/usb.x": nil,
+				"/dev/dri/usb.*": nil,
+			},
+		},
+		{
+			name: "doublestar",
+			mode: DoublestarMatchMode,
+			path: "/dev/**/controlC{card}",
+			glob: "/dev/**/controlC*",
+			matches: map[string][]string{
+				"/dev/controlC0":       {"0"},
+				"/dev/snd/controlC1":   {"1"},
+				"/dev/a/snd/controlC2": {"2"},
+				"/devcontrolC0":        nil,
+			},
+		},
+		{
+			name: "trailing doublestar",
+			mode: DoublestarMatchMode,
+			path: "/dev/{bus}/**",
+			glob: "/dev/*/**",
+			matches: map[string][]string{
+				"/dev/usb/001/002": {"usb"},
+				"/dev/usb":         nil,
+			},
+		},
+		{
+			name: "regex",
+			mode: RegexMatchMode,
+			path: `/dev/snd/pcmC(?P<card>\d+)D(?P<device>\d+)[cp]`,
+			glob: `/dev/snd/pcmC(?P<card>\d+)D(?P<device>\d+)[cp]`,
+			matches: map[string][]string{
+				"/dev/snd/pcmC1D2c": {"1", "2"},
+				"/dev/snd/pcmC1D2":  nil,
+			},
+		},
+		{
+			name: "regex without named groups",
+			mode: RegexMatchMode,
+			path: `/dev/video(\d+)`,
+			glob: `/dev/video(\d+)`,
+		},
+		{
+			name: "duplicate key",
+			path: "/dev/{a}/{a}",
+			err:  true,
+		},
+		{
+			name: "malformed glob",
+			path: "/dev/{a}[",
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.mode == "" {
+				tc.mode = GlobMatchMode
+			}
+			p, err := parsePattern(tc.mode, tc.path)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t; got %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if p.search != tc.glob {
+				t.Errorf("expected glob %q; got %q", tc.glob, p.search)
+			}
+			if (len(p.keys) > 0) != (tc.matches != nil) {
+				t.Errorf("expected keys %t; got %v", tc.matches != nil, p.keys)
+			}
+			for path, expected := range tc.matches {
+				_, values, ok := p.captures(path)
+				key := keyOf(values)
+				if ok != (expected != nil) || (ok && !slices.Equal(key, expected)) {
+					t.Errorf("%s: expected key %v; got %v, %t", path, expected, key, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptures(t *testing.T) {
+	for _, tc := range []struct {
+		mode     MatchMode
+		path     string
+		name     string
+		captures []string
+	}{
+		{mode: GlobMatchMode, path: "/dev/ttyUSB*", name: "/dev/ttyUSB3", captures: []string{"/dev/ttyUSB3", "3"}},
+		{mode: GlobMatchMode, path: "/dev/tty[A-Z]?[^a]", name: "/dev/ttyACM", captures: []string{"/dev/ttyACM", "A", "C", "M"}},
+		{mode: GlobMatchMode, path: "/dev/snd/pcmC{card}D*c", name: "/dev/snd/pcmC1D0c", captures: []string{"/dev/snd/pcmC1D0c", "1", "0"}},
+		{mode: DoublestarMatchMode, path: "/dev/**/card*", name: "/dev/dri/a/card0", captures: []string{"/dev/dri/a/card0", "dri/a/", "0"}},
+		{mode: RegexMatchMode, path: `/dev/ttyUSB(\d+)`, name: "/dev/ttyUSB12", captures: []string{"/dev/ttyUSB12", "12"}},
+	} {
+		p, err := parsePattern(tc.mode, tc.path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.path, err)
+		}
+		captures, _, ok := p.captures(tc.name)
+		if !ok || !slices.Equal(captures, tc.captures) {
+			t.Errorf("%s: expected captures %q; got %q", tc.path, tc.captures, captures)
+		}
+	}
+}
+
+func TestCompareKeys(t *testing.T) {
+	keys := [][]string{{"10"}, {"b"}, {"2"}, {"a"}, {"2", "1"}, {"2", "0"}}
+	slices.SortFunc(keys, compareKeys)
+	expected := [][]string{{"2"}, {"2", "0"}, {"2", "1"}, {"10"}, {"a"}, {"b"}}
+	if !slices.EqualFunc(keys, expected, slices.Equal) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		paths []*Path
+		err   bool
+	}{
+		{
+			name:  "same keys",
+			paths: []*Path{{Path: "/dev/snd/controlC{card}"}, {Path: "/dev/snd/pcmC{card}D0c"}, {Path: "/dev/snd/seq", Limit: 4}},
+		},
+		{
+			name:  "same keys in another order",
+			paths: []*Path{{Path: "/dev/{a}/{b}"}, {Path: "/dev/{b}-{a}"}},
+		},
+		{
+			name:  "different keys",
+			paths: []*Path{{Path: "/dev/snd/controlC{card}"}, {Path: "/dev/snd/pcmC{c}D0c"}},
+			err:   true,
+		},
+		{
+			name:  "limit",
+			paths: []*Path{{Path: "/dev/snd/controlC{card}", Limit: 2}},
+			err:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := (&Group{Paths: tc.paths}).validateKeys(); (err != nil) != tc.err {
+				t.Errorf("expected error %t; got %v", tc.err, err)
+			}
+		})
+	}
+}
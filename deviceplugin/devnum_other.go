@@ -0,0 +1,31 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package deviceplugin
+
+import "io/fs"
+
+// deviceNumber returns the major and minor numbers of the device node described by the given FileInfo.
+// Device numbers are only supported on Linux.
+func deviceNumber(_ fs.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// inode returns the inode number of the file described by the given FileInfo.
+// Inode numbers are only supported on Linux.
+func inode(_ fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,158 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// skipRecords is the number of skipped entries that are remembered.
+const skipRecords = 100
+
+var (
+	// errNotUSBDevice is returned for entries of the USB bus that are not devices, e.g. buses and interfaces.
+	errNotUSBDevice = errors.New("not a USB device")
+	// errMalformedAttribute is returned for attributes whose values cannot be parsed.
+	errMalformedAttribute = errors.New("malformed device data")
+)
+
+// SkipReason describes why an entry of a discovery source was skipped.
+type SkipReason string
+
+const (
+	// NotDeviceSkipReason means that the entry is not a device, e.g. a USB bus or interface, or has no device node.
+	NotDeviceSkipReason SkipReason = "not-device"
+	// UnreadableSkipReason means that an attribute of the device could not be read, e.g. because it is missing or not permitted.
+	UnreadableSkipReason SkipReason = "unreadable"
+	// MalformedSkipReason means that an attribute of the device could not be parsed.
+	MalformedSkipReason SkipReason = "malformed"
+	// TimeoutSkipReason means that reading the device did not finish in time.
+	TimeoutSkipReason SkipReason = "timeout"
+	// WrongTypeSkipReason means that a path matched a file-system node that is not of the type of the path.
+	WrongTypeSkipReason SkipReason = "wrong-type"
+)
+
+// skipReason classifies the error for which an entry was skipped.
+func skipReason(err error) SkipReason {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return TimeoutSkipReason
+	case errors.Is(err, errNotUSBDevice), errors.Is(err, errNoDeviceNode):
+		return NotDeviceSkipReason
+	case errors.Is(err, errMalformedAttribute):
+		return MalformedSkipReason
+	case errors.Is(err, errWrongType):
+		return WrongTypeSkipReason
+	default:
+		return UnreadableSkipReason
+	}
+}
+
+// diagnostic describes an entry of a source that was skipped during discovery.
+type diagnostic struct {
+	source string
+	path   string
+	reason SkipReason
+	err    error
+}
+
+// reportDiagnostics logs the given diagnostics.
+// Entries that are not devices are expected, so they are only logged at debug level.
+func reportDiagnostics(logger log.Logger, diagnostics []diagnostic) {
+	for _, d := range diagnostics {
+		l := level.Warn(logger)
+		if d.reason == NotDeviceSkipReason {
+			l = level.Debug(logger)
+		}
+		_ = l.Log("msg", "skipped device during discovery", "source", d.source, "path", d.path, "reason", d.reason, "err", d.err)
+	}
+}
+
+// SkipRecord describes the last time an entry of a discovery source was skipped.
+type SkipRecord struct {
+	Source    string     `json:"source"`
+	Path      string     `json:"path"`
+	Reason    SkipReason `json:"reason"`
+	Error     string     `json:"error"`
+	FirstSeen time.Time  `json:"firstSeen"`
+	LastSeen  time.Time  `json:"lastSeen"`
+	// Count is the number of scans that skipped the entry for the same reason since FirstSeen.
+	Count uint `json:"count"`
+}
+
+// skipLog remembers the most recently skipped entries.
+// Entries are skipped in every scan, so every entry is remembered once.
+type skipLog struct {
+	size int
+
+	mu      sync.Mutex
+	records map[[2]string]*SkipRecord
+}
+
+func newSkipLog(size int) *skipLog {
+	return &skipLog{size: size, records: make(map[[2]string]*SkipRecord)}
+}
+
+// add records the given diagnostics and returns those whose entries
+// were not skipped before or were skipped for another reason.
+func (l *skipLog) add(diagnostics []diagnostic, now time.Time) []diagnostic {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var changed []diagnostic
+	for _, d := range diagnostics {
+		k := [2]string{d.source, d.path}
+		r, ok := l.records[k]
+		if !ok || r.Reason != d.reason || r.Error != d.err.Error() {
+			changed = append(changed, d)
+			r = &SkipRecord{Source: d.source, Path: d.path, Reason: d.reason, Error: d.err.Error(), FirstSeen: now}
+			l.records[k] = r
+		}
+		r.LastSeen = now
+		r.Count++
+	}
+	// Forget the records that were seen least recently.
+	if over := len(l.records) - l.size; over > 0 {
+		for _, r := range l.list()[len(l.records)-over:] {
+			delete(l.records, [2]string{r.Source, r.Path})
+		}
+	}
+	return changed
+}
+
+// all returns the remembered records, most recently seen first.
+func (l *skipLog) all() []SkipRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.list()
+}
+
+func (l *skipLog) list() []SkipRecord {
+	records := make([]SkipRecord, 0, len(l.records))
+	for _, r := range l.records {
+		records = append(records, *r)
+	}
+	slices.SortFunc(records, func(a, b SkipRecord) int {
+		return cmp.Or(b.LastSeen.Compare(a.LastSeen), cmp.Compare(a.Source, b.Source), cmp.Compare(a.Path, b.Path))
+	})
+	return records
+}
@@ -0,0 +1,60 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSkipLog(t *testing.T) {
+	l := newSkipLog(2)
+	t0 := time.Unix(0, 0)
+	unreadable := diagnostic{source: usbSource, path: "/sys/bus/usb/devices/1-1", reason: UnreadableSkipReason, err: errors.New("permission denied")}
+	iface := diagnostic{source: usbSource, path: "/sys/bus/usb/devices/1-1:1.0", reason: NotDeviceSkipReason, err: errNotUSBDevice}
+
+	if changed := l.add([]diagnostic{unreadable, iface}, t0); len(changed) != 2 {
+		t.Errorf("expected 2 new records; got %d", len(changed))
+	}
+	// Entries skipped again for the same reason are only counted.
+	if changed := l.add([]diagnostic{unreadable, iface}, t0.Add(time.Second)); len(changed) != 0 {
+		t.Errorf("expected no new records; got %d", len(changed))
+	}
+	records := l.all()
+	if len(records) != 2 || records[0].Count != 2 || !records[0].FirstSeen.Equal(t0) || !records[0].LastSeen.Equal(t0.Add(time.Second)) {
+		t.Errorf("expected 2 records seen twice; got %v", records)
+	}
+
+	// Entries skipped for another reason start a new record.
+	malformed := unreadable
+	malformed.reason = MalformedSkipReason
+	malformed.err = errMalformedAttribute
+	if changed := l.add([]diagnostic{malformed}, t0.Add(2*time.Second)); len(changed) != 1 {
+		t.Errorf("expected 1 new record; got %d", len(changed))
+	}
+	records = l.all()
+	if records[0].Path != malformed.path || records[0].Reason != MalformedSkipReason || records[0].Count != 1 {
+		t.Errorf("expected a new malformed record first; got %v", records[0])
+	}
+
+	// The least recently seen records are forgotten.
+	other := diagnostic{source: usbSource, path: "/sys/bus/usb/devices/1-2", reason: UnreadableSkipReason, err: errors.New("no such file")}
+	l.add([]diagnostic{other}, t0.Add(3*time.Second))
+	records = l.all()
+	if len(records) != 2 || records[0].Path != other.path || records[1].Path != malformed.path {
+		t.Errorf("expected the interface record to be forgotten; got %v", records)
+	}
+}
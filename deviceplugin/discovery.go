@@ -0,0 +1,247 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// discoverySourceTimeout is how long discovering the devices of a source may take.
+	discoverySourceTimeout = 3 * time.Second
+	// discoveryReadTimeout is how long a single read from sysfs may take.
+	discoveryReadTimeout = 1 * time.Second
+)
+
+// The sources of devices.
+const (
+	usbSource    = "usb"
+	pathSource   = "path"
+	sysDevSource = "sysdev"
+	sysfsSource  = "sysfs"
+)
+
+// maxDiscoveryCalls is how many blocking file-system calls of discovery may be in flight at once.
+const maxDiscoveryCalls = 64
+
+// discoveryCalls holds a slot for every blocking file-system call of discovery that is in flight.
+var discoveryCalls = make(chan struct{}, maxDiscoveryCalls)
+
+// withContext runs the given function and returns its result, or the error
+// of the given context if the context is done first. Go cannot interrupt
+// blocking file-system calls, so the function keeps running in the background
+// until it returns. Abandoned functions keep their slot of discoveryCalls until
+// they return, so at most maxDiscoveryCalls goroutines can hang on a file system;
+// once all slots are taken, calls fail when the given context is done without starting the function.
+func withContext[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	var zero T
+	select {
+	case discoveryCalls <- struct{}{}:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	ch := make(chan result, 1)
+	go func() {
+		defer func() { <-discoveryCalls }()
+		v, err := f()
+		ch <- result{v, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// readFile reads the named file, giving up after discoveryReadTimeout
+// or when the given context is done.
+func readFile(ctx context.Context, fsys fs.FS, name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryReadTimeout)
+	defer cancel()
+	data, err := withContext(ctx, func() ([]byte, error) {
+		return fs.ReadFile(fsys, name)
+	})
+	if ctx.Err() != nil && err == ctx.Err() {
+		return nil, fmt.Errorf("failed to read %q: %w", name, err)
+	}
+	return data, err
+}
+
+// readDir lists the named directory, giving up after discoveryReadTimeout
+// or when the given context is done.
+func readDir(ctx context.Context, fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryReadTimeout)
+	defer cancel()
+	entries, err := withContext(ctx, func() ([]fs.DirEntry, error) {
+		return fs.ReadDir(fsys, name)
+	})
+	if ctx.Err() != nil && err == ctx.Err() {
+		return nil, fmt.Errorf("failed to list %q: %w", name, err)
+	}
+	return entries, err
+}
+
+// findPaths returns the paths matching the given pattern in the given mode,
+// giving up when the given context is done.
+func findPaths(ctx context.Context, fsys fs.FS, mode MatchMode, pattern string) ([]string, error) {
+	return withContext(ctx, func() ([]string, error) {
+		return find(ctx, fsys, mode, pattern)
+	})
+}
+
+// DiscoveryErrorPolicy decides which devices are advertised when discovery fails.
+type DiscoveryErrorPolicy string
+
+const (
+	// FailDiscoveryErrorPolicy fails the ListAndWatch streams, so that
+	// the kubelet considers all devices of the resource gone.
+	FailDiscoveryErrorPolicy DiscoveryErrorPolicy = "fail"
+	// KeepDiscoveryErrorPolicy keeps advertising the devices of the last successful discovery.
+	KeepDiscoveryErrorPolicy DiscoveryErrorPolicy = "keep"
+	// PartialDiscoveryErrorPolicy advertises the devices of the groups that were discovered successfully.
+	PartialDiscoveryErrorPolicy DiscoveryErrorPolicy = "partial"
+)
+
+// DiscoveryErrorPolicies is the list of all discovery error policies.
+var DiscoveryErrorPolicies = []DiscoveryErrorPolicy{FailDiscoveryErrorPolicy, KeepDiscoveryErrorPolicy, PartialDiscoveryErrorPolicy}
+
+// keepDevices returns copies of the given devices.
+// If unhealthy is true, then the copies are marked unhealthy.
+func keepDevices(devices map[string]device, unhealthy bool) []device {
+	kept := make([]device, 0, len(devices))
+	for _, d := range devices {
+		health := d.Health
+		if unhealthy {
+			health = v1beta1.Unhealthy
+		}
+		d.Device = &v1beta1.Device{ID: d.ID, Health: health, Topology: d.Topology}
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+// Duration is a time.Duration that is written as a string, e.g. "30s", in configuration.
+type Duration time.Duration
+
+// UnmarshalJSON parses durations like "1m30s".
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(strings.TrimSpace(string(data)), `"`)
+	if str == "null" || str == "" {
+		return nil
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("malformed duration %q: %w", str, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// String returns the duration formatted like "1m30s".
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// ToDurationHookFunc handles mapstructure decode of durations.
+func ToDurationHookFunc(f, t reflect.Type, data interface{}) (interface{}, error) {
+	if t != reflect.TypeOf(Duration(0)) {
+		return data, nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		return time.ParseDuration(data.(string))
+	default:
+		return data, nil
+	}
+}
+
+// settle holds back devices that appeared until they were discovered in AppearAfter
+// consecutive refreshes and keeps advertising devices that vanished as unhealthy
+// until they stayed away for the RemovalGracePeriod, so that flapping devices
+// do not churn the capacity of the node.
+// Devices found by the first refresh, e.g. when the plugin starts or takes over from another instance,
+// and devices that the kubelet checkpoint reports as allocated are not new and appear at once.
+// It must only be called by refreshDevices.
+func (gp *GenericPlugin) settle(devices []device) []device {
+	gp.mu.Lock()
+	old := gp.devices
+	gp.mu.Unlock()
+
+	now := gp.now()
+	first := !gp.settled
+	gp.settled = true
+	var allocated map[string]struct{}
+	if gp.ds.AppearAfter > 1 {
+		allocated = gp.claims.allocated(gp.ds.Name)
+	}
+	found := make(map[string]struct{}, len(devices))
+	settled := make([]device, 0, len(devices))
+	for _, d := range devices {
+		found[d.ID] = struct{}{}
+		delete(gp.missing, d.ID)
+		_, known := old[d.ID]
+		if _, ok := allocated[d.ID]; ok || known || first || gp.ds.AppearAfter <= 1 {
+			delete(gp.appearing, d.ID)
+			settled = append(settled, d)
+			continue
+		}
+		gp.appearing[d.ID]++
+		if gp.appearing[d.ID] < gp.ds.AppearAfter {
+			_ = level.Debug(gp.logger).Log("msg", "holding back appearing device", "id", d.ID, "refreshes", gp.appearing[d.ID])
+			continue
+		}
+		delete(gp.appearing, d.ID)
+		settled = append(settled, d)
+	}
+	// Devices must be discovered in consecutive refreshes to appear.
+	for id := range gp.appearing {
+		if _, ok := found[id]; !ok {
+			delete(gp.appearing, id)
+		}
+	}
+
+	for id, d := range old {
+		if _, ok := found[id]; ok || gp.ds.RemovalGracePeriod <= 0 {
+			continue
+		}
+		since, ok := gp.missing[id]
+		if !ok {
+			since = now
+			gp.missing[id] = since
+		}
+		if now.Sub(since) >= time.Duration(gp.ds.RemovalGracePeriod) {
+			delete(gp.missing, id)
+			continue
+		}
+		_ = level.Debug(gp.logger).Log("msg", "keeping vanished device", "id", id, "since", since)
+		d.Device = &v1beta1.Device{ID: d.ID, Health: v1beta1.Unhealthy, Topology: d.Topology}
+		settled = append(settled, d)
+	}
+	return settled
+}
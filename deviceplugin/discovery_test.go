@@ -0,0 +1,79 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/squat/generic-device-plugin/absolute"
+)
+
+func TestWithContextBounded(t *testing.T) {
+	release := make(chan struct{})
+	var started atomic.Int32
+	for range maxDiscoveryCalls + 4 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := withContext(ctx, func() (struct{}, error) {
+			started.Add(1)
+			<-release
+			return struct{}{}, nil
+		})
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected %v; got %v", context.DeadlineExceeded, err)
+		}
+	}
+	// Abandoned calls hold their slots, so no more calls were started.
+	if n := started.Load(); n != maxDiscoveryCalls {
+		t.Errorf("expected %d started calls; got %d", maxDiscoveryCalls, n)
+	}
+
+	close(release)
+	for start := time.Now(); len(discoveryCalls) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out waiting for abandoned calls to return")
+		}
+	}
+	if v, err := withContext(context.Background(), func() (int, error) { return 1, nil }); err != nil || v != 1 {
+		t.Errorf("expected (1, nil); got (%d, %v)", v, err)
+	}
+}
+
+func TestReadDirTimeout(t *testing.T) {
+	bfs := &blockingFS{
+		FS:      fstest.MapFS{"sys/class/video4linux/video0/uevent": {Data: []byte("DEVNAME=video0\n")}},
+		block:   map[string]bool{"sys/class/video4linux": true},
+		release: make(chan struct{}),
+	}
+	defer close(bfs.release)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := enumerateSysfsClass(context.Background(), absolute.New(bfs, "/"), sysClassDir, "video4linux")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v; got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected listing the class to time out")
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// usbScanConcurrency is the maximum number of USB devices that are queried concurrently.
+const usbScanConcurrency = 8
+
+// memo holds the result of a computation that runs at most once.
+type memo[T any] struct {
+	once sync.Once
+	done chan struct{}
+	v    T
+	err  error
+}
+
+// get starts the given computation in the background the first time it is called
+// and waits for its result or until the given context is done.
+// Callers that give up do not stop the computation, so others can still use its result.
+func (m *memo[T]) get(ctx context.Context, f func() (T, error)) (T, error) {
+	m.once.Do(func() {
+		m.done = make(chan struct{})
+		go func() {
+			defer close(m.done)
+			m.v, m.err = f()
+		}()
+	})
+	select {
+	case <-m.done:
+		return m.v, m.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// dirKey identifies a directory, so that a directory that is
+// removed and created again, e.g. when a USB device is replugged, is not confused with the old one.
+type dirKey struct {
+	path    string
+	inode   uint64
+	modTime int64
+}
+
+// attributeCache caches the attributes of USB devices read from sysfs.
+// A nil attributeCache caches nothing.
+type attributeCache struct {
+	mu      sync.Mutex
+	entries map[dirKey]*memo[*usbDevice]
+	// used holds the keys looked up since the last prune.
+	used map[dirKey]struct{}
+}
+
+func newAttributeCache() *attributeCache {
+	return &attributeCache{
+		entries: make(map[dirKey]*memo[*usbDevice]),
+		used:    make(map[dirKey]struct{}),
+	}
+}
+
+// get returns the attributes of the USB device in the directory described
+// by the given path and FileInfo, reading them only if they are not cached.
+// Read errors other than missing attributes are not cached.
+func (c *attributeCache) get(ctx context.Context, fsys fs.FS, path string, fi fs.FileInfo) (*usbDevice, error) {
+	if c == nil {
+		return queryUSBDeviceCharacteristicsByDirectory(ctx, fsys, path)
+	}
+	k := dirKey{path: path, inode: inode(fi), modTime: fi.ModTime().UnixNano()}
+	c.mu.Lock()
+	m, ok := c.entries[k]
+	if !ok {
+		m = new(memo[*usbDevice])
+		c.entries[k] = m
+	}
+	c.used[k] = struct{}{}
+	c.mu.Unlock()
+	// The reads are shared, so they must not be cut short by the first caller giving up.
+	dev, err := m.get(ctx, func() (*usbDevice, error) {
+		return queryUSBDeviceCharacteristicsByDirectory(context.Background(), fsys, path)
+	})
+	// Keep reads that are still in flight, so that a hung device does not pile up blocked reads;
+	// forget failed reads, so that they are retried in the next scan.
+	if err != nil && ctx.Err() == nil && !errors.Is(err, fs.ErrNotExist) {
+		c.mu.Lock()
+		if c.entries[k] == m {
+			delete(c.entries, k)
+		}
+		c.mu.Unlock()
+	}
+	return dev, err
+}
+
+// prune drops the entries of directories that were not looked up since the last prune.
+func (c *attributeCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if _, ok := c.used[k]; !ok {
+			delete(c.entries, k)
+		}
+	}
+	c.used = make(map[dirKey]struct{})
+}
+
+// usbScan holds the USB devices attached to the system and the devices that were skipped.
+type usbScan struct {
+	devices     []usbDevice
+	diagnostics []diagnostic
+}
+
+// sysDevScan holds the device nodes listed in /sys/dev and the entries that were skipped.
+type sysDevScan struct {
+	devices     []sysDevice
+	diagnostics []diagnostic
+}
+
+// pathSearch is a search for the paths matching a pattern.
+type pathSearch struct {
+	mode    MatchMode
+	pattern string
+}
+
+// scan holds the results of discovering all sources once.
+type scan struct {
+	mu      sync.Mutex
+	paths   map[pathSearch]*memo[[]string]
+	usb     memo[usbScan]
+	sysDev  memo[sysDevScan]
+	classes map[string]*memo[[]sysfsDevice]
+	// reported holds the source and path of every entry that plugins reported as skipped in the scan.
+	reported map[[2]string]struct{}
+}
+
+func newScan() *scan {
+	return &scan{
+		paths:    make(map[pathSearch]*memo[[]string]),
+		classes:  make(map[string]*memo[[]sysfsDevice]),
+		reported: make(map[[2]string]struct{}),
+	}
+}
+
+// DiscoveryEngine discovers devices for all of the resources served by a process.
+// Every source, i.e. every path pattern, the USB bus, /sys/dev, and every sysfs class, is scanned at most once per interval
+// no matter how many resources use it, and the attributes of USB devices are only read
+// again when their sysfs directories change.
+// A DiscoveryEngine is safe for concurrent use.
+type DiscoveryEngine struct {
+	fs       fs.FS
+	interval time.Duration
+	logger   log.Logger
+	cache    *attributeCache
+	skips    *skipLog
+
+	mu          sync.Mutex
+	scan        *scan
+	subscribers map[chan struct{}]struct{}
+
+	// metrics
+	scansCounter   *prometheus.CounterVec
+	skippedCounter *prometheus.CounterVec
+}
+
+// NewDiscoveryEngine creates a new DiscoveryEngine that discovers devices
+// in the file system rooted at hostRoot.
+func NewDiscoveryEngine(hostRoot string, logger log.Logger, reg prometheus.Registerer) *DiscoveryEngine {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	e := &DiscoveryEngine{
+		fs:          hostFS(hostRoot),
+		interval:    deviceCheckInterval,
+		logger:      logger,
+		cache:       newAttributeCache(),
+		skips:       newSkipLog(skipRecords),
+		scan:        newScan(),
+		subscribers: make(map[chan struct{}]struct{}),
+		scansCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "generic_device_plugin_discovery_scans_total",
+			Help: "The total number of times that a discovery source was scanned.",
+		}, []string{"source"}),
+		skippedCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "generic_device_plugin_discovery_skipped_total",
+			Help: "The total number of discovery source entries that were skipped, by reason.",
+		}, []string{"source", "reason"}),
+	}
+	if reg != nil {
+		reg.MustRegister(e.scansCounter, e.skippedCounter)
+	}
+	return e
+}
+
+// Run starts a new scan every interval and tells all subscribers
+// to refresh their devices until the given context is cancelled.
+func (e *DiscoveryEngine) Run(ctx context.Context) error {
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			e.next()
+		}
+	}
+}
+
+// next discards the results of the current scan and notifies all subscribers.
+func (e *DiscoveryEngine) next() {
+	e.cache.prune()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scan = newScan()
+	for ch := range e.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Skipped returns the entries of discovery sources that were skipped most recently, most recently seen first,
+// so that it can be told why a device is not discovered.
+func (e *DiscoveryEngine) Skipped() []SkipRecord {
+	return e.skips.all()
+}
+
+// current returns the current scan.
+func (e *DiscoveryEngine) current() *scan {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.scan
+}
+
+// subscribe returns a channel that receives a value whenever a new scan starts,
+// as well as a function to cancel the subscription.
+func (e *DiscoveryEngine) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subscribers[ch] = struct{}{}
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subscribers, ch)
+	}
+}
+
+// find returns the paths matching the given pattern in the given mode in the current scan.
+// Searching gives up after discoverySourceTimeout.
+func (e *DiscoveryEngine) find(ctx context.Context, mode MatchMode, pattern string) ([]string, error) {
+	if mode == "" {
+		mode = GlobMatchMode
+	}
+	s := e.current()
+	s.mu.Lock()
+	k := pathSearch{mode: mode, pattern: pattern}
+	m, ok := s.paths[k]
+	if !ok {
+		m = new(memo[[]string])
+		s.paths[k] = m
+	}
+	s.mu.Unlock()
+	return m.get(ctx, func() ([]string, error) {
+		e.scansCounter.WithLabelValues(string(mode)).Inc()
+		ctx, cancel := context.WithTimeout(context.Background(), discoverySourceTimeout)
+		defer cancel()
+		return findPaths(ctx, e.fs, mode, pattern)
+	})
+}
+
+// usbDevices returns the USB devices attached to the system in the current scan.
+// Enumerating the devices gives up after discoverySourceTimeout.
+// Skipped entries are counted in every scan but only logged when their reason changes.
+func (e *DiscoveryEngine) usbDevices(ctx context.Context) ([]usbDevice, error) {
+	s, err := e.current().usb.get(ctx, func() (usbScan, error) {
+		e.scansCounter.WithLabelValues(usbSource).Inc()
+		ctx, cancel := context.WithTimeout(context.Background(), discoverySourceTimeout)
+		defer cancel()
+		devices, diagnostics, err := enumerateUSBDevices(ctx, e.fs, usbDevicesDir, e.cache)
+		e.report(diagnostics)
+		return usbScan{devices: devices, diagnostics: diagnostics}, err
+	})
+	return s.devices, err
+}
+
+// sysDevices returns the device nodes listed in /sys/dev in the current scan.
+// Enumerating the device nodes gives up after discoverySourceTimeout.
+func (e *DiscoveryEngine) sysDevices(ctx context.Context) ([]sysDevice, error) {
+	s, err := e.current().sysDev.get(ctx, func() (sysDevScan, error) {
+		e.scansCounter.WithLabelValues(sysDevSource).Inc()
+		ctx, cancel := context.WithTimeout(context.Background(), discoverySourceTimeout)
+		defer cancel()
+		devices, diagnostics, err := enumerateSysDevices(ctx, e.fs, sysDevDir)
+		e.report(diagnostics)
+		return sysDevScan{devices: devices, diagnostics: diagnostics}, err
+	})
+	return s.devices, err
+}
+
+// sysfsDevices returns the devices of the given sysfs class that have device nodes in the current scan.
+// Enumerating the devices gives up after discoverySourceTimeout.
+func (e *DiscoveryEngine) sysfsDevices(ctx context.Context, class string) ([]sysfsDevice, error) {
+	s := e.current()
+	s.mu.Lock()
+	m, ok := s.classes[class]
+	if !ok {
+		m = new(memo[[]sysfsDevice])
+		s.classes[class] = m
+	}
+	s.mu.Unlock()
+	return m.get(ctx, func() ([]sysfsDevice, error) {
+		e.scansCounter.WithLabelValues(sysfsSource).Inc()
+		ctx, cancel := context.WithTimeout(context.Background(), discoverySourceTimeout)
+		defer cancel()
+		devices, diagnostics, err := enumerateSysfsClass(ctx, e.fs, sysClassDir, class)
+		e.report(diagnostics)
+		return devices, err
+	})
+}
+
+// report counts and remembers the given diagnostics and logs those whose entries were not skipped before
+// or were skipped for another reason.
+func (e *DiscoveryEngine) report(diagnostics []diagnostic) {
+	for _, d := range diagnostics {
+		e.skippedCounter.WithLabelValues(d.source, string(d.reason)).Inc()
+	}
+	reportDiagnostics(e.logger, e.skips.add(diagnostics, time.Now()))
+}
+
+// reportOnce reports the given diagnostics of entries that were not reported in the current scan yet,
+// so that entries that every plugin skips, e.g. paths of the wrong type, are counted once per scan.
+func (e *DiscoveryEngine) reportOnce(diagnostics []diagnostic) {
+	s := e.current()
+	s.mu.Lock()
+	var fresh []diagnostic
+	for _, d := range diagnostics {
+		k := [2]string{d.source, d.path}
+		if _, ok := s.reported[k]; ok {
+			continue
+		}
+		s.reported[k] = struct{}{}
+		fresh = append(fresh, d)
+	}
+	s.mu.Unlock()
+	e.report(fresh)
+}
+
+// report reports the given diagnostics once per scan through the plugin's DiscoveryEngine if it has one
+// and otherwise logs them.
+func (gp *GenericPlugin) report(diagnostics []diagnostic) {
+	if gp.engine != nil {
+		gp.engine.reportOnce(diagnostics)
+		return
+	}
+	reportDiagnostics(gp.logger, diagnostics)
+}
+
+// find returns the paths matching the given pattern in the given mode,
+// using the plugin's DiscoveryEngine if it has one.
+func (gp *GenericPlugin) find(ctx context.Context, mode MatchMode, pattern string) ([]string, error) {
+	if gp.engine != nil {
+		return gp.engine.find(ctx, mode, pattern)
+	}
+	return findPaths(ctx, gp.fs, mode, pattern)
+}
+
+// usbDevices returns the USB devices attached to the system,
+// using the plugin's DiscoveryEngine if it has one.
+func (gp *GenericPlugin) usbDevices(ctx context.Context) ([]usbDevice, error) {
+	if gp.engine != nil {
+		return gp.engine.usbDevices(ctx)
+	}
+	devices, diagnostics, err := enumerateUSBDevices(ctx, gp.fs, usbDevicesDir, nil)
+	reportDiagnostics(gp.logger, diagnostics)
+	return devices, err
+}
+
+// sysDevices returns the device nodes listed in /sys/dev,
+// using the plugin's DiscoveryEngine if it has one.
+func (gp *GenericPlugin) sysDevices(ctx context.Context) ([]sysDevice, error) {
+	if gp.engine != nil {
+		return gp.engine.sysDevices(ctx)
+	}
+	devices, diagnostics, err := enumerateSysDevices(ctx, gp.fs, sysDevDir)
+	reportDiagnostics(gp.logger, diagnostics)
+	return devices, err
+}
+
+// sysfsDevices returns the devices of the given sysfs class that have device nodes,
+// using the plugin's DiscoveryEngine if it has one.
+func (gp *GenericPlugin) sysfsDevices(ctx context.Context, class string) ([]sysfsDevice, error) {
+	if gp.engine != nil {
+		return gp.engine.sysfsDevices(ctx, class)
+	}
+	devices, diagnostics, err := enumerateSysfsClass(ctx, gp.fs, sysClassDir, class)
+	reportDiagnostics(gp.logger, diagnostics)
+	return devices, err
+}
@@ -0,0 +1,269 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/squat/generic-device-plugin/absolute"
+)
+
+// countingFS counts how often every file is opened and
+// how many files are open at the same time.
+type countingFS struct {
+	fs.FS
+	delay time.Duration
+
+	mu      sync.Mutex
+	opens   map[string]int
+	open    int
+	maxOpen int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.open++
+	c.maxOpen = max(c.maxOpen, c.open)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.open--
+		c.mu.Unlock()
+	}()
+	time.Sleep(c.delay)
+	return c.FS.Open(name)
+}
+
+func (c *countingFS) count(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opens[name]
+}
+
+// blockingFS blocks opening the given files until it is released.
+type blockingFS struct {
+	fs.FS
+	block   map[string]bool
+	release chan struct{}
+}
+
+func (b *blockingFS) Open(name string) (fs.File, error) {
+	if b.block[name] {
+		<-b.release
+	}
+	return b.FS.Open(name)
+}
+
+// usbFS returns a file system with n USB devices of the same model.
+func usbFS(n int) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := range n {
+		dir := fmt.Sprintf("sys/bus/usb/devices/1-%d", i+1)
+		fsys[dir] = &fstest.MapFile{Mode: fs.ModeDir}
+		fsys[path.Join(dir, "idVendor")] = &fstest.MapFile{Data: []byte("1a86\n")}
+		fsys[path.Join(dir, "idProduct")] = &fstest.MapFile{Data: []byte("7523\n")}
+		fsys[path.Join(dir, "busnum")] = &fstest.MapFile{Data: []byte("1\n")}
+		fsys[path.Join(dir, "devnum")] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", i+2))}
+	}
+	fsys["dev/ttyUSB0"] = charDevice
+	return fsys
+}
+
+func TestDiscoveryEngine(t *testing.T) {
+	mfs := usbFS(2)
+	cfs := &countingFS{FS: mfs, opens: make(map[string]int)}
+	e := NewDiscoveryEngine("/", nil, nil)
+	e.fs = absolute.New(cfs, "/")
+
+	var plugins []*GenericPlugin
+	for _, name := range []string{"ch340", "serial", "both"} {
+		ds := &DeviceSpec{Name: name}
+		if name != "serial" {
+			ds.Groups = append(ds.Groups, &Group{USBSpecs: []*USBSpec{{Vendor: 0x1a86, Product: 0x7523}}})
+		}
+		if name != "ch340" {
+			ds.Groups = append(ds.Groups, &Group{Paths: []*Path{{Path: "/dev/ttyUSB*"}}})
+		}
+		ds.Default()
+		gp := NewGenericPlugin(ds, t.TempDir(), nil, nil, true, nil, "/", WithDiscoveryEngine(e)).(*plugin).DevicePluginServer.(*GenericPlugin)
+		plugins = append(plugins, gp)
+	}
+	refresh := func() {
+		var wg sync.WaitGroup
+		for _, gp := range plugins {
+			wg.Go(func() {
+				if _, err := gp.refreshDevices(context.Background()); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+		wg.Wait()
+	}
+	expect := func(name string, n int) {
+		t.Helper()
+		if c := cfs.count(name); c != n {
+			t.Errorf("expected %q to be opened %d times; got %d", name, n, c)
+		}
+	}
+
+	// Every source is scanned once for all plugins.
+	refresh()
+	expect("sys/bus/usb/devices", 1)
+	expect("dev", 1)
+	expect("sys/bus/usb/devices/1-1/idVendor", 1)
+	// Each group of USB specifications makes up one device.
+	for i, n := range []int{1, 1, 2} {
+		if len(plugins[i].devices) != n {
+			t.Errorf("%s: expected %d devices; got %d", plugins[i].ds.Name, n, len(plugins[i].devices))
+		}
+	}
+
+	// Sources are scanned again in the next scan, but the attributes of unchanged devices are cached.
+	e.next()
+	refresh()
+	expect("sys/bus/usb/devices", 2)
+	expect("dev", 2)
+	expect("sys/bus/usb/devices/1-1/idVendor", 1)
+
+	// Replugged devices are read again.
+	mfs["sys/bus/usb/devices/1-1"] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: time.Unix(1, 0)}
+	e.next()
+	refresh()
+	expect("sys/bus/usb/devices/1-1/idVendor", 2)
+	expect("sys/bus/usb/devices/1-2/idVendor", 1)
+}
+
+func TestDiscoveryEngineReportOnce(t *testing.T) {
+	e := NewDiscoveryEngine("/", nil, nil)
+	e.fs = absolute.New(fstest.MapFS{"dev/ttyUSB0": {}}, "/")
+	var plugins []*GenericPlugin
+	for _, name := range []string{"serial", "modem"} {
+		ds := &DeviceSpec{Name: name, Groups: []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*", Type: CharDevicePathType}}}}}
+		ds.Default()
+		plugins = append(plugins, NewGenericPlugin(ds, t.TempDir(), nil, nil, false, nil, "/", WithDiscoveryEngine(e)).(*plugin).DevicePluginServer.(*GenericPlugin))
+	}
+	refresh := func() {
+		t.Helper()
+		for _, gp := range plugins {
+			if _, err := gp.refreshDevices(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	expect := func(count uint) {
+		t.Helper()
+		skipped := e.Skipped()
+		if len(skipped) != 1 || skipped[0].Reason != WrongTypeSkipReason || skipped[0].Count != count {
+			t.Errorf("expected /dev/ttyUSB0 to be skipped for the wrong type %d times; got %+v", count, skipped)
+		}
+	}
+
+	// A path of the wrong type that every plugin skips is counted once per scan.
+	refresh()
+	refresh()
+	expect(1)
+	e.next()
+	refresh()
+	expect(2)
+}
+
+func TestEnumerateUSBDevicesConcurrency(t *testing.T) {
+	cfs := &countingFS{FS: usbFS(4 * usbScanConcurrency), delay: time.Millisecond, opens: make(map[string]int)}
+	devs, _, err := enumerateUSBDevices(context.Background(), absolute.New(cfs, "/"), usbDevicesDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devs) != 4*usbScanConcurrency {
+		t.Errorf("expected %d devices; got %d", 4*usbScanConcurrency, len(devs))
+	}
+	if cfs.maxOpen > usbScanConcurrency {
+		t.Errorf("expected at most %d concurrent reads; got %d", usbScanConcurrency, cfs.maxOpen)
+	}
+}
+
+func TestEnumerateUSBDevicesTimeout(t *testing.T) {
+	bfs := &blockingFS{FS: usbFS(3), block: map[string]bool{"sys/bus/usb/devices/1-2/idVendor": true}, release: make(chan struct{})}
+	defer close(bfs.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	devs, diagnostics, err := enumerateUSBDevices(ctx, absolute.New(bfs, "/"), usbDevicesDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devs) != 2 {
+		t.Errorf("expected 2 devices; got %d", len(devs))
+	}
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic; got %d", len(diagnostics))
+	}
+	if d := diagnostics[0]; d.source != usbSource || d.path != "/sys/bus/usb/devices/1-2" {
+		t.Errorf("expected diagnostic for %q from %q; got %q from %q", "/sys/bus/usb/devices/1-2", usbSource, d.path, d.source)
+	}
+}
+
+func TestAllocateDuringDiscovery(t *testing.T) {
+	bfs := &blockingFS{FS: fstest.MapFS{"dev/ttyUSB0": charDevice}, block: map[string]bool{}, release: make(chan struct{})}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "serial",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*"}}}},
+	}, bfs)
+	if _, err := gp.refreshDevices(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, _ := gp.listResponse("")
+	id := res.Devices[0].ID
+
+	// Block the next discovery.
+	bfs.block["dev"] = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = gp.refreshDevices(ctx)
+	}()
+
+	allocated := make(chan error, 1)
+	go func() {
+		_, err := gp.Allocate(context.Background(), &v1beta1.AllocateRequest{ContainerRequests: []*v1beta1.ContainerAllocateRequest{{DevicesIds: []string{id}}}})
+		allocated <- err
+	}()
+	select {
+	case err := <-allocated:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected Allocate not to wait for discovery")
+	}
+
+	// Canceling the discovery returns without waiting for the blocked read.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected discovery to return when canceled")
+	}
+	close(bfs.release)
+}
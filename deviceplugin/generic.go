@@ -16,10 +16,15 @@ package deviceplugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/go-kit/log"
@@ -43,40 +48,137 @@ type DeviceSpec struct {
 	Name string `json:"name"`
 	// Groups is a list of groups of devices that should be scheduled under the same name.
 	Groups []*Group `json:"groups"`
+	// OnDiscoveryError specifies which devices are advertised when discovery fails.
+	// This can be one of:
+	// * fail - fail the ListAndWatch streams, so that the kubelet considers all devices gone;
+	// * keep - keep advertising the devices of the last successful discovery; or
+	// * partial - advertise the devices of the groups that were discovered successfully.
+	// When unspecified, OnDiscoveryError defaults to fail.
+	OnDiscoveryError DiscoveryErrorPolicy `json:"onDiscoveryError,omitempty"`
+	// UnhealthyAfter is the number of consecutive discovery failures after which
+	// the kept devices are marked unhealthy when OnDiscoveryError is keep.
+	// When unspecified, kept devices stay healthy.
+	UnhealthyAfter uint `json:"unhealthyAfter,omitempty"`
+	// RemovalGracePeriod is how long devices that vanished are still advertised as unhealthy
+	// before they are removed, e.g. to ride out USB re-enumeration.
+	// When unspecified, vanished devices are removed immediately.
+	RemovalGracePeriod Duration `json:"removalGracePeriod,omitempty"`
+	// AppearAfter is the number of consecutive refreshes in which new devices
+	// must be discovered before they are advertised.
+	// Devices found by the first refresh and devices that are allocated according to the kubelet checkpoint
+	// are advertised immediately.
+	// When unspecified, new devices are advertised immediately.
+	AppearAfter uint `json:"appearAfter,omitempty"`
+	// OverlapPolicy overrides the process-wide overlap policy for the devices of this resource,
+	// e.g. none to never mark them unhealthy because of allocations under other resources.
+	// Two resources are compared according to the weaker of their policies.
+	// When unspecified, the process-wide overlap policy applies.
+	OverlapPolicy OverlapPolicy `json:"overlapPolicy,omitempty"`
 }
 
 // Default applies default values for all fields that can be left empty.
 func (d *DeviceSpec) Default() {
+	if d.OnDiscoveryError == "" {
+		d.OnDiscoveryError = FailDiscoveryErrorPolicy
+	}
 	for _, g := range d.Groups {
 		if g.Count == 0 {
 			g.Count = 1
 		}
+		if g.Identity == "" {
+			g.Identity = PathIdentityScheme
+		}
 		for _, p := range g.Paths {
+			if p.Match == "" {
+				p.Match = GlobMatchMode
+			}
 			if p.Limit == 0 {
 				p.Limit = 1
 			}
 			if p.Type == "" {
 				p.Type = DevicePathType
 			}
-			if p.Type == DevicePathType && p.Permissions == "" {
+			if p.Type.isDevice() && p.Permissions == "" {
 				p.Permissions = "mrw"
 			}
 		}
 	}
 }
 
+// Validate checks that the DeviceSpec is valid.
+func (d *DeviceSpec) Validate() error {
+	switch d.OnDiscoveryError {
+	case "", FailDiscoveryErrorPolicy, PartialDiscoveryErrorPolicy:
+		if d.UnhealthyAfter > 0 {
+			return fmt.Errorf("unhealthyAfter requires the %q discovery error policy", KeepDiscoveryErrorPolicy)
+		}
+	case KeepDiscoveryErrorPolicy:
+	default:
+		return fmt.Errorf("unknown discovery error policy %q", d.OnDiscoveryError)
+	}
+	if d.OverlapPolicy != "" && !slices.Contains(OverlapPolicies, d.OverlapPolicy) {
+		return fmt.Errorf("unknown overlap policy %q", d.OverlapPolicy)
+	}
+	if d.RemovalGracePeriod < 0 {
+		return fmt.Errorf("removal grace period %s must not be negative", d.RemovalGracePeriod)
+	}
+	for i, g := range d.Groups {
+		if err := g.validate(); err != nil {
+			return fmt.Errorf("invalid group %d: %w", i, err)
+		}
+		for j, p := range g.Paths {
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("invalid path %d of group %d: %w", j, i, err)
+			}
+		}
+		for j, s := range g.Sysfs {
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("invalid sysfs spec %d of group %d: %w", j, i, err)
+			}
+		}
+		if err := g.validateKeys(); err != nil {
+			return fmt.Errorf("invalid group %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
 // Group represents a set of devices that should be grouped and mounted into a container together as one single meta-device.
 type Group struct {
 	// Paths is the list of devices of which the device group consists.
 	// Paths can be globs, in which case each device matched by the path will be schedulable `Count` times.
 	// When the paths have differing cardinalities, that is, the globs match different numbers of devices,
 	// the cardinality of each path is capped at the lowest cardinality.
+	// Paths can declare correlation keys, e.g. /dev/snd/controlC{card} and /dev/snd/pcmC{card}D0c,
+	// in which case their matches are joined on the values of the keys instead of their sorted positions.
 	Paths []*Path `json:"paths"`
 	// USBSpecs is the list of USB specifications that this device group consists of.
 	USBSpecs []*USBSpec `json:"usb"`
+	// Sysfs is the list of sysfs specifications that select device nodes of this device group
+	// by their class and the attributes of their devices, e.g. the video4linux devices whose index is 0.
+	// The selected device nodes are grouped with the matches of the Paths as if they were matched by Paths.
+	Sysfs []*SysfsSpec `json:"sysfs,omitempty"`
 	// Count specifies how many times this group can be mounted concurrently.
 	// When unspecified, Count defaults to 1.
 	Count uint `json:"count,omitempty"`
+	// Identity specifies which attribute of the host devices is used to derive device IDs.
+	// Attributes other than the path keep IDs stable when devices are replugged or renumbered.
+	// This can be one of:
+	// * path - the host paths of the devices;
+	// * serial - the USB serial numbers of the devices;
+	// * port - the physical USB ports of the devices, e.g. 1-1.2; or
+	// * udev - the udev ID_PATH properties of the devices.
+	// Devices for which the attribute cannot be determined fall back to their host path.
+	// When unspecified, Identity defaults to path.
+	Identity IdentityScheme `json:"identity,omitempty"`
+	// IDTemplate is a Go template for rendering human-readable device IDs instead of hashes.
+	// The template can use the fields .Path, .Serial, .Port, .IDPath, .Vendor, and .Product
+	// of the first host device, .Nodes for all of the host devices, .Index for the index
+	// of the match within the group, and .Slot for the copy of the group when Count is greater than 1,
+	// e.g. "{{.Serial}}-{{.Slot}}".
+	IDTemplate string `json:"idTemplate,omitempty"`
+
+	idTemplate *template.Template
 }
 
 // device wraps the v1.beta1.Device type to add context about
@@ -87,6 +189,18 @@ type device struct {
 	mounts      []*v1beta1.Mount
 }
 
+// hostPaths returns the paths of all of the host file-system nodes that make up the device.
+func (d device) hostPaths() []string {
+	paths := make([]string, 0, len(d.deviceSpecs)+len(d.mounts))
+	for _, ds := range d.deviceSpecs {
+		paths = append(paths, ds.HostPath)
+	}
+	for _, m := range d.mounts {
+		paths = append(paths, m.HostPath)
+	}
+	return paths
+}
+
 // GenericPlugin is a plugin for generic devices that can:
 // * be found using either a file path or a USB identifier; and
 // * mounted and used without special logic.
@@ -96,17 +210,56 @@ type GenericPlugin struct {
 	devices            map[string]device
 	logger             log.Logger
 	enableUSBDiscovery bool
+	claims             *ClaimRegistry
+	// engine is shared with the other plugins of the process.
+	engine *DiscoveryEngine
 	// Allows us to abstract away the file system for testing.
 	fs fs.FS
 	mu sync.Mutex
+	// subscribers are notified whenever the devices change.
+	subscribers map[chan struct{}]struct{}
+	// scanned is true once the devices were discovered for the first time.
+	scanned bool
+	// err is the error of the last discovery.
+	err error
+	// failures is the number of consecutive discovery failures.
+	failures uint
+	// settled is true once the devices were settled for the first time.
+	settled bool
+	// appearing counts the consecutive refreshes in which new devices were discovered.
+	appearing map[string]uint
+	// missing records since when vanished devices are missing.
+	missing map[string]time.Time
+	// orphans holds the allocated device IDs that were reported as no longer discovered.
+	orphans map[string]struct{}
+	// explanations holds how many devices every group of paths yielded in the last discovery.
+	explanations map[int]groupExplanation
+	now          func() time.Time
+	// mkdirAll creates directories in the host's file system.
+	mkdirAll func(path string, perm fs.FileMode) error
+	// drained is closed once the devices are drained.
+	drained   chan struct{}
+	drainOnce sync.Once
+	// sent is closed once a ListAndWatch stream sent devices for the first time.
+	sent     chan struct{}
+	sendOnce sync.Once
 
 	// metrics
-	deviceGauge        prometheus.Gauge
-	allocationsCounter prometheus.Counter
+	deviceGauge         prometheus.Gauge
+	allocationsCounter  prometheus.Counter
+	idCollisionsCounter prometheus.Counter
+	discoveryFailures   prometheus.Counter
+	groupDevices        *prometheus.GaugeVec
+	groupCappedBy       *prometheus.GaugeVec
 }
 
 // NewGenericPlugin creates a new plugin for a generic device.
-func NewGenericPlugin(ds *DeviceSpec, pluginDir string, logger log.Logger, reg prometheus.Registerer, enableUSBDiscovery bool) Plugin {
+// If the given ClaimRegistry is not nil, then devices that overlap with devices
+// allocated under other resources are marked unhealthy according to the overlap policy
+// of the DeviceSpec or, if it has none, of the registry.
+// Devices are discovered in the file system rooted at hostRoot, while the paths
+// given to the kubelet remain relative to the host's root.
+func NewGenericPlugin(ds *DeviceSpec, pluginDir string, logger log.Logger, reg prometheus.Registerer, enableUSBDiscovery bool, claims *ClaimRegistry, hostRoot string, opts ...Option) Plugin {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -116,7 +269,19 @@ func NewGenericPlugin(ds *DeviceSpec, pluginDir string, logger log.Logger, reg p
 		devices:            make(map[string]device),
 		logger:             logger,
 		enableUSBDiscovery: enableUSBDiscovery,
-		fs:                 absolute.New(os.DirFS("/"), "/"),
+		claims:             claims,
+		fs:                 hostFS(hostRoot),
+		subscribers:        make(map[chan struct{}]struct{}),
+		appearing:          make(map[string]uint),
+		missing:            make(map[string]time.Time),
+		orphans:            make(map[string]struct{}),
+		explanations:       make(map[int]groupExplanation),
+		now:                time.Now,
+		mkdirAll: func(path string, perm fs.FileMode) error {
+			return os.MkdirAll(filepath.Join(hostRoot, path), perm)
+		},
+		drained: make(chan struct{}),
+		sent:    make(chan struct{}),
 		deviceGauge: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "generic_device_plugin_devices",
 			Help: "The number of devices managed by this device plugin.",
@@ -125,44 +290,121 @@ func NewGenericPlugin(ds *DeviceSpec, pluginDir string, logger log.Logger, reg p
 			Name: "generic_device_plugin_allocations_total",
 			Help: "The total number of device allocations made by this device plugin.",
 		}),
+		idCollisionsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "generic_device_plugin_device_id_collisions_total",
+			Help: "The total number of discovered devices that were dropped because their ID was already taken.",
+		}),
+		discoveryFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "generic_device_plugin_discovery_failures_total",
+			Help: "The total number of times that discovering the devices failed.",
+		}),
+		groupDevices: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "generic_device_plugin_group_devices",
+			Help: "The number of devices yielded by a group of paths in the last discovery.",
+		}, []string{"group"}),
+		groupCappedBy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "generic_device_plugin_group_capped_by",
+			Help: "Which path capped the number of devices yielded by a group of paths in the last discovery. Always 1.",
+		}, []string{"group", "path"}),
+	}
+
+	// Plugins share the DiscoveryEngine given in the options, if any.
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.engine != nil {
+		gp.engine = o.engine
+		gp.fs = o.engine.fs
+	}
+
+	if claims != nil && ds.OverlapPolicy != "" {
+		claims.override(ds.Name, ds.OverlapPolicy)
 	}
 
 	if reg != nil {
-		reg.MustRegister(gp.deviceGauge, gp.allocationsCounter)
+		reg.MustRegister(gp.deviceGauge, gp.allocationsCounter, gp.idCollisionsCounter, gp.discoveryFailures, gp.groupDevices, gp.groupCappedBy)
 	}
 
-	return NewPlugin(ds.Name, pluginDir, gp, logger, prometheus.WrapRegistererWithPrefix("generic_", reg))
+	return NewPlugin(ds.Name, pluginDir, gp, logger, prometheus.WrapRegistererWithPrefix("generic_", reg), opts...)
 }
 
-func (gp *GenericPlugin) discover() (devices []device, err error) {
-	path, err := gp.discoverPath()
+// hostFS returns a file system that resolves absolute host paths inside of the given root directory.
+func hostFS(root string) fs.FS {
+	if root == "" {
+		root = "/"
+	}
+	return absolute.New(os.DirFS(root), "/")
+}
+
+// If discovering some groups fails, then the devices of the
+// other groups are returned along with the error.
+// Every source gives up after discoverySourceTimeout.
+func (gp *GenericPlugin) discover(ctx context.Context) (devices []device, err error) {
+	pathCtx, cancel := context.WithTimeout(ctx, discoverySourceTimeout)
+	defer cancel()
+	path, err := gp.discoverPath(pathCtx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover path devices: %w", err)
+		err = fmt.Errorf("failed to discover path devices: %w", err)
 	}
 
 	if !gp.enableUSBDiscovery {
-		return path, nil
+		return path, err
 	}
 
-	usb, err := gp.discoverUSB()
-	if err != nil {
-		return nil, fmt.Errorf("failed to discover usb devices: %w", err)
+	usbCtx, cancel := context.WithTimeout(ctx, discoverySourceTimeout)
+	defer cancel()
+	usb, usbErr := gp.discoverUSB(usbCtx)
+	if usbErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to discover usb devices: %w", usbErr))
 	}
 	// This action just bolts the usb entries onto the path ones, but we're not too worried about reuse since we're about to return anyway.
-	return append(path, usb...), nil
+	return append(path, usb...), err
 }
 
 // refreshDevices updates the devices available to the
 // generic device plugin and returns a boolean indicating
 // if everything is OK, i.e. if the devices are the same ones as before.
-func (gp *GenericPlugin) refreshDevices() (bool, error) {
-	devices, err := gp.discover()
+// Discovery errors are handled according to the DeviceSpec's OnDiscoveryError policy.
+// The lock is only held to swap the devices, so Allocate never waits for discovery.
+func (gp *GenericPlugin) refreshDevices(ctx context.Context) (bool, error) {
+	devices, err := gp.discover(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to refresh devices: %v", err)
+		gp.discoveryFailures.Inc()
+		gp.failures++
+		switch gp.ds.OnDiscoveryError {
+		case KeepDiscoveryErrorPolicy:
+			unhealthy := gp.ds.UnhealthyAfter > 0 && gp.failures >= gp.ds.UnhealthyAfter
+			_ = level.Warn(gp.logger).Log("msg", "keeping devices of last discovery", "failures", gp.failures, "unhealthy", unhealthy, "err", err)
+			gp.mu.Lock()
+			devices = keepDevices(gp.devices, unhealthy)
+			gp.mu.Unlock()
+		case PartialDiscoveryErrorPolicy:
+			_ = level.Warn(gp.logger).Log("msg", "advertising devices of successfully discovered groups", "failures", gp.failures, "err", err)
+		default:
+			return false, fmt.Errorf("failed to refresh devices: %v", err)
+		}
+	} else {
+		gp.failures = 0
 	}
+	devices = gp.settle(gp.dropCollisions(devices))
 
 	gp.deviceGauge.Set(float64(len(devices)))
 
+	if gp.claims.enabled() {
+		paths := make(map[string][]string, len(devices))
+		for _, d := range devices {
+			paths[d.ID] = d.hostPaths()
+		}
+		gp.claims.update(gp.ds.Name, paths)
+		for _, d := range devices {
+			if gp.claims.conflicts(gp.ds.Name, paths[d.ID]) {
+				d.Health = v1beta1.Unhealthy
+			}
+		}
+	}
+	gp.reconcile(devices)
+
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
@@ -171,10 +413,10 @@ func (gp *GenericPlugin) refreshDevices() (bool, error) {
 
 	equal := true
 	// Add the new devices to the map and check
-	// if they were in the old map.
+	// if they were in the old map with the same health.
 	for _, d := range devices {
 		gp.devices[d.ID] = d
-		if _, ok := old[d.ID]; !ok {
+		if o, ok := old[d.ID]; !ok || o.Health != d.Health {
 			equal = false
 		}
 	}
@@ -191,6 +433,23 @@ func (gp *GenericPlugin) refreshDevices() (bool, error) {
 	return true, nil
 }
 
+// dropCollisions removes and reports all devices whose ID was already
+// taken by an earlier device, so that no device silently replaces another.
+func (gp *GenericPlugin) dropCollisions(devices []device) []device {
+	seen := make(map[string]device, len(devices))
+	unique := devices[:0]
+	for _, d := range devices {
+		if o, ok := seen[d.ID]; ok {
+			_ = level.Warn(gp.logger).Log("msg", "dropping device with colliding ID", "id", d.ID, "paths", strings.Join(d.hostPaths(), ","), "existing", strings.Join(o.hostPaths(), ","))
+			gp.idCollisionsCounter.Inc()
+			continue
+		}
+		seen[d.ID] = d
+		unique = append(unique, d)
+	}
+	return unique
+}
+
 // GetDeviceState always returns healthy.
 func (gp *GenericPlugin) GetDeviceState(_ string) string {
 	return v1beta1.Healthy
@@ -198,14 +457,20 @@ func (gp *GenericPlugin) GetDeviceState(_ string) string {
 
 // Allocate assigns generic devices to a Pod.
 func (gp *GenericPlugin) Allocate(_ context.Context, req *v1beta1.AllocateRequest) (*v1beta1.AllocateResponse, error) {
+	select {
+	case <-gp.drained:
+		return nil, errors.New("devices are draining")
+	default:
+	}
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 	res := &v1beta1.AllocateResponse{
 		ContainerResponses: make([]*v1beta1.ContainerAllocateResponse, 0, len(req.ContainerRequests)),
 	}
+	// Check all requested devices before claiming any of them,
+	// so that a failed allocation does not leave claims behind.
+	var ids []string
 	for _, r := range req.ContainerRequests {
-		resp := new(v1beta1.ContainerAllocateResponse)
-		// Add all requested devices to to response.
 		for _, id := range r.DevicesIds {
 			d, ok := gp.devices[id]
 			if !ok {
@@ -214,6 +479,17 @@ func (gp *GenericPlugin) Allocate(_ context.Context, req *v1beta1.AllocateReques
 			if d.Health != v1beta1.Healthy {
 				return nil, fmt.Errorf("requested device is not healthy %q", id)
 			}
+			ids = append(ids, id)
+		}
+	}
+	if err := gp.claims.claim(gp.ds.Name, ids...); err != nil {
+		return nil, fmt.Errorf("requested device is not available: %w", err)
+	}
+	for _, r := range req.ContainerRequests {
+		resp := new(v1beta1.ContainerAllocateResponse)
+		// Add all requested devices to to response.
+		for _, id := range r.DevicesIds {
+			d := gp.devices[id]
 			resp.Devices = append(resp.Devices, d.deviceSpecs...)
 			resp.Mounts = append(resp.Mounts, d.mounts...)
 		}
@@ -223,35 +499,146 @@ func (gp *GenericPlugin) Allocate(_ context.Context, req *v1beta1.AllocateReques
 	return res, nil
 }
 
-// GetDevicePluginOptions always returns an empty response.
+// GetDevicePluginOptions tells the kubelet to ask for preferred allocations.
 func (gp *GenericPlugin) GetDevicePluginOptions(_ context.Context, _ *v1beta1.Empty) (*v1beta1.DevicePluginOptions, error) {
-	return &v1beta1.DevicePluginOptions{}, nil
+	return &v1beta1.DevicePluginOptions{GetPreferredAllocationAvailable: true}, nil
 }
 
-// ListAndWatch lists all devices and then refreshes every deviceCheckInterval.
+// ListAndWatch lists all devices and then sends the new list whenever the devices change
+// until the kubelet disconnects or discovery fails.
 func (gp *GenericPlugin) ListAndWatch(_ *v1beta1.Empty, stream v1beta1.DevicePlugin_ListAndWatchServer) error {
 	_ = level.Info(gp.logger).Log("msg", "starting listwatch")
-	if _, err := gp.refreshDevices(); err != nil {
-		return err
-	}
-	ok := false
-	var err error
+	updates, unsubscribe := gp.subscribe()
+	defer unsubscribe()
 	for {
-		if !ok {
-			res := new(v1beta1.ListAndWatchResponse)
-			for _, dev := range gp.devices {
-				res.Devices = append(res.Devices, &v1beta1.Device{ID: dev.ID, Health: dev.Health})
+		select {
+		case <-stream.Context().Done():
+			_ = level.Info(gp.logger).Log("msg", "stopping listwatch")
+			return nil
+		case <-gp.drained:
+			// Keep the stream open until the plugin stops, so that
+			// the kubelet does not consider the plugin gone early.
+			res, _ := gp.listResponse(v1beta1.Unhealthy)
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+			<-stream.Context().Done()
+			return nil
+		case <-updates:
+			res, err := gp.listResponse("")
+			if err != nil {
+				return err
 			}
 			if err := stream.Send(res); err != nil {
 				return err
 			}
+			if len(res.Devices) > 0 {
+				gp.sendOnce.Do(func() {
+					close(gp.sent)
+				})
+			}
+		}
+	}
+}
+
+// loop refreshes the devices every deviceCheckInterval, or whenever
+// the DiscoveryEngine starts a new scan, until the given context is cancelled.
+func (gp *GenericPlugin) loop(ctx context.Context) {
+	var ticks <-chan time.Time
+	var scans <-chan struct{}
+	if gp.engine != nil {
+		var unsubscribe func()
+		scans, unsubscribe = gp.engine.subscribe()
+		defer unsubscribe()
+	} else {
+		t := time.NewTicker(deviceCheckInterval)
+		defer t.Stop()
+		ticks = t.C
+	}
+	for {
+		gp.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		case <-scans:
 		}
-		<-time.After(deviceCheckInterval)
-		ok, err = gp.refreshDevices()
-		if err != nil {
-			return err
+	}
+}
+
+// refresh refreshes the devices and notifies all
+// subscribers if the devices changed or discovery failed.
+func (gp *GenericPlugin) refresh(ctx context.Context) {
+	equal, err := gp.refreshDevices(ctx)
+	if ctx.Err() != nil {
+		// The plugin is stopping, so the result is meaningless.
+		return
+	}
+	if err != nil {
+		_ = level.Warn(gp.logger).Log("msg", "failed to discover devices", "err", err)
+	}
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if equal && gp.scanned && gp.err == nil {
+		return
+	}
+	gp.scanned = true
+	gp.err = err
+	for ch := range gp.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel that receives a value whenever the devices change,
+// as well as a function to cancel the subscription.
+// Notifications are coalesced, so subscribers must always list the latest devices.
+// If the devices were already discovered, then the channel is ready immediately.
+func (gp *GenericPlugin) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if gp.scanned {
+		ch <- struct{}{}
+	}
+	gp.subscribers[ch] = struct{}{}
+	return ch, func() {
+		gp.mu.Lock()
+		defer gp.mu.Unlock()
+		delete(gp.subscribers, ch)
+	}
+}
+
+// listResponse lists all devices along with the error of the last discovery.
+// If health is not empty, then it overrides the health of every device.
+func (gp *GenericPlugin) listResponse(health string) (*v1beta1.ListAndWatchResponse, error) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	res := new(v1beta1.ListAndWatchResponse)
+	for _, dev := range gp.devices {
+		h := dev.Health
+		if health != "" {
+			h = health
 		}
+		res.Devices = append(res.Devices, &v1beta1.Device{ID: dev.ID, Health: h})
 	}
+	return res, gp.err
+}
+
+// drain makes all ListAndWatch streams report every device as unhealthy
+// and makes all further allocations fail.
+func (gp *GenericPlugin) drain() {
+	gp.drainOnce.Do(func() {
+		close(gp.drained)
+	})
+}
+
+// advertised returns a channel that is closed once a ListAndWatch stream sent devices for the first time,
+// so that instances of the plugin only hand off their resource once this instance advertises capacity.
+func (gp *GenericPlugin) advertised() <-chan struct{} {
+	return gp.sent
 }
 
 // PreStartContainer always returns an empty response.
@@ -259,7 +646,56 @@ func (gp *GenericPlugin) PreStartContainer(_ context.Context, _ *v1beta1.PreStar
 	return &v1beta1.PreStartContainerResponse{}, nil
 }
 
-// GetPreferredAllocation always returns an empty response.
-func (gp *GenericPlugin) GetPreferredAllocation(context.Context, *v1beta1.PreferredAllocationRequest) (*v1beta1.PreferredAllocationResponse, error) {
-	return &v1beta1.PreferredAllocationResponse{}, nil
+// GetPreferredAllocation prefers devices whose host devices are not allocated yet,
+// neither under this resource, e.g. as another copy of a group with a count,
+// nor under any other resource.
+func (gp *GenericPlugin) GetPreferredAllocation(_ context.Context, req *v1beta1.PreferredAllocationRequest) (*v1beta1.PreferredAllocationResponse, error) {
+	allocated := gp.claims.allocated(gp.ds.Name)
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	var busy []string
+	for id := range allocated {
+		if d, ok := gp.devices[id]; ok {
+			busy = append(busy, d.hostPaths()...)
+		}
+	}
+	res := &v1beta1.PreferredAllocationResponse{
+		ContainerResponses: make([]*v1beta1.ContainerPreferredAllocationResponse, 0, len(req.ContainerRequests)),
+	}
+	for _, r := range req.ContainerRequests {
+		ids := slices.Clone(r.MustIncludeDeviceIDs)
+		candidates := slices.DeleteFunc(slices.Clone(r.AvailableDeviceIDs), func(id string) bool {
+			return slices.Contains(ids, id)
+		})
+		slices.Sort(candidates)
+		// Spread the allocation over distinct host devices.
+		taken := slices.Clone(busy)
+		for _, id := range ids {
+			taken = append(taken, gp.devices[id].hostPaths()...)
+		}
+		score := func(id string) int {
+			var s int
+			paths := gp.devices[id].hostPaths()
+			if overlaps(ExactOverlapPolicy, paths, taken) {
+				s++
+			}
+			if gp.claims.conflicts(gp.ds.Name, paths) {
+				s += 2
+			}
+			return s
+		}
+		for len(ids) < int(r.AllocationSize) && len(candidates) > 0 {
+			best := 0
+			for i := 1; i < len(candidates); i++ {
+				if score(candidates[i]) < score(candidates[best]) {
+					best = i
+				}
+			}
+			ids = append(ids, candidates[best])
+			taken = append(taken, gp.devices[candidates[best]].hostPaths()...)
+			candidates = slices.Delete(candidates, best, best+1)
+		}
+		res.ContainerResponses = append(res.ContainerResponses, &v1beta1.ContainerPreferredAllocationResponse{DeviceIDs: ids})
+	}
+	return res, nil
 }
@@ -0,0 +1,490 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/squat/generic-device-plugin/absolute"
+)
+
+// fakeListAndWatchServer records the responses sent on a ListAndWatch stream.
+type fakeListAndWatchServer struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses chan *v1beta1.ListAndWatchResponse
+}
+
+func (s *fakeListAndWatchServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeListAndWatchServer) Send(res *v1beta1.ListAndWatchResponse) error {
+	s.responses <- res
+	return nil
+}
+
+// charDevice is a character device node in a test file system.
+var charDevice = &fstest.MapFile{Mode: fs.ModeDevice | fs.ModeCharDevice}
+
+// blockDevice is a block device node in a test file system.
+var blockDevice = &fstest.MapFile{Mode: fs.ModeDevice}
+
+// newTestGenericPlugin creates a generic plugin that discovers devices in the given file system.
+// Directories are created in the file system if it is an fstest.MapFS.
+func newTestGenericPlugin(t *testing.T, ds *DeviceSpec, fsys fs.FS) *GenericPlugin {
+	t.Helper()
+	ds.Default()
+	gp := NewGenericPlugin(ds, t.TempDir(), nil, nil, false, nil, "/").(*plugin).DevicePluginServer.(*GenericPlugin)
+	gp.fs = absolute.New(fsys, "/")
+	gp.mkdirAll = func(path string, perm fs.FileMode) error {
+		m, ok := fsys.(fstest.MapFS)
+		if !ok {
+			return fs.ErrPermission
+		}
+		m[strings.TrimPrefix(path, "/")] = &fstest.MapFile{Mode: fs.ModeDir | perm}
+		return nil
+	}
+	return gp
+}
+
+func TestListAndWatch(t *testing.T) {
+	fsys := fstest.MapFS{"dev/ttyUSB0": charDevice}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "serial",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*"}}}},
+	}, fsys)
+
+	type stream struct {
+		*fakeListAndWatchServer
+		cancel context.CancelFunc
+		done   chan error
+	}
+	start := func() stream {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := stream{
+			fakeListAndWatchServer: &fakeListAndWatchServer{ctx: ctx, responses: make(chan *v1beta1.ListAndWatchResponse, 1)},
+			cancel:                 cancel,
+			done:                   make(chan error, 1),
+		}
+		go func() {
+			s.done <- gp.ListAndWatch(&v1beta1.Empty{}, s)
+		}()
+		return s
+	}
+	expect := func(s stream, n int) {
+		t.Helper()
+		select {
+		case res := <-s.responses:
+			if len(res.Devices) != n {
+				t.Errorf("expected %d devices; got %d", n, len(res.Devices))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d devices", n)
+		}
+	}
+	subscribers := func() int {
+		gp.mu.Lock()
+		defer gp.mu.Unlock()
+		return len(gp.subscribers)
+	}
+
+	// Streams opened before the first discovery wait for it.
+	streams := []stream{start(), start()}
+	for subscribers() != len(streams) {
+		time.Sleep(time.Millisecond)
+	}
+	gp.refresh(context.Background())
+	for _, s := range streams {
+		expect(s, 1)
+	}
+	// Streams opened later receive the current devices immediately.
+	streams = append(streams, start())
+	expect(streams[2], 1)
+
+	// Allocations race with discovery and with the streams.
+	allocated := make(chan struct{})
+	go func() {
+		defer close(allocated)
+		for range 10 {
+			_, _ = gp.Allocate(context.Background(), &v1beta1.AllocateRequest{})
+		}
+	}()
+	// Unchanged devices are not sent again.
+	gp.refresh(context.Background())
+	fsys["dev/ttyUSB1"] = charDevice
+	gp.refresh(context.Background())
+	<-allocated
+	for _, s := range streams {
+		expect(s, 2)
+	}
+
+	// Cancelled streams unsubscribe.
+	streams[0].cancel()
+	if err := <-streams[0].done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := subscribers(); n != 2 {
+		t.Errorf("expected 2 subscribers; got %d", n)
+	}
+	for _, s := range streams[1:] {
+		s.cancel()
+		<-s.done
+	}
+	if n := subscribers(); n != 0 {
+		t.Errorf("expected no subscribers; got %d", n)
+	}
+}
+
+func TestLoop(t *testing.T) {
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "fuse",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/fuse"}}}},
+	}, fstest.MapFS{"dev/fuse": charDevice})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gp.loop(ctx)
+	}()
+	updates, unsubscribe := gp.subscribe()
+	defer unsubscribe()
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for discovery")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected loop to stop when the context is cancelled")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ds := &DeviceSpec{
+		Name:   "fuse",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/fuse"}}}},
+	}
+	gp := newTestGenericPlugin(t, ds, fstest.MapFS{"dev/fuse": charDevice})
+	gp.refresh(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeListAndWatchServer{ctx: ctx, responses: make(chan *v1beta1.ListAndWatchResponse, 1)}
+	done := make(chan error)
+	go func() {
+		done <- gp.ListAndWatch(&v1beta1.Empty{}, stream)
+	}()
+
+	expect := func(health string) {
+		t.Helper()
+		select {
+		case res := <-stream.responses:
+			if len(res.Devices) != 1 || res.Devices[0].Health != health {
+				t.Errorf("expected 1 %s device; got %v", health, res.Devices)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s device", health)
+		}
+	}
+	expect(v1beta1.Healthy)
+	res, _ := gp.listResponse("")
+	id := res.Devices[0].ID
+
+	gp.drain()
+	expect(v1beta1.Unhealthy)
+	if _, err := gp.Allocate(context.Background(), &v1beta1.AllocateRequest{ContainerRequests: []*v1beta1.ContainerAllocateRequest{{DevicesIds: []string{id}}}}); err == nil {
+		t.Error("expected allocation to fail while draining")
+	}
+	// Draining twice must not panic.
+	gp.drain()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected stream to stay open while draining; got %v", err)
+	default:
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAdvertised(t *testing.T) {
+	fsys := fstest.MapFS{}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "serial",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*"}}}},
+	}, fsys)
+	gp.refresh(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeListAndWatchServer{ctx: ctx, responses: make(chan *v1beta1.ListAndWatchResponse)}
+	done := make(chan error)
+	go func() {
+		done <- gp.ListAndWatch(&v1beta1.Empty{}, stream)
+	}()
+
+	// Sending no devices does not count as advertising them.
+	if res := <-stream.responses; len(res.Devices) != 0 {
+		t.Fatalf("expected no devices; got %v", res.Devices)
+	}
+	fsys["dev/ttyUSB0"] = charDevice
+	go gp.refresh(context.Background())
+	select {
+	case <-gp.advertised():
+		t.Fatal("expected devices not to be advertised before they are sent")
+	default:
+	}
+	if res := <-stream.responses; len(res.Devices) != 1 {
+		t.Fatalf("expected 1 device; got %v", res.Devices)
+	}
+	select {
+	case <-gp.advertised():
+	case <-time.After(5 * time.Second):
+		t.Error("expected devices to be advertised once they are sent")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOnDiscoveryError(t *testing.T) {
+	// A malformed pattern makes discovering its group fail.
+	const broken = "/dev/[tty"
+	for _, tc := range []struct {
+		name   string
+		policy DiscoveryErrorPolicy
+		after  uint
+		// health is the expected health of every device after each failed refresh,
+		// or nil if the refresh is expected to fail.
+		health [][]string
+	}{
+		{
+			name:   "fail",
+			policy: FailDiscoveryErrorPolicy,
+			health: [][]string{nil, nil},
+		},
+		{
+			name:   "keep",
+			policy: KeepDiscoveryErrorPolicy,
+			health: [][]string{
+				{v1beta1.Healthy, v1beta1.Healthy},
+				{v1beta1.Healthy, v1beta1.Healthy},
+			},
+		},
+		{
+			name:   "keep unhealthy after 2",
+			policy: KeepDiscoveryErrorPolicy,
+			after:  2,
+			health: [][]string{
+				{v1beta1.Healthy, v1beta1.Healthy},
+				{v1beta1.Unhealthy, v1beta1.Unhealthy},
+			},
+		},
+		{
+			name:   "partial",
+			policy: PartialDiscoveryErrorPolicy,
+			health: [][]string{
+				{v1beta1.Healthy},
+				{v1beta1.Healthy},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gp := newTestGenericPlugin(t, &DeviceSpec{
+				Name: "serial",
+				Groups: []*Group{
+					{Paths: []*Path{{Path: "/dev/ttyUSB*"}}},
+					{Paths: []*Path{{Path: "/dev/ttyACM*"}}},
+				},
+				OnDiscoveryError: tc.policy,
+				UnhealthyAfter:   tc.after,
+			}, fstest.MapFS{"dev/ttyUSB0": charDevice, "dev/ttyACM0": charDevice})
+			if _, err := gp.refreshDevices(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			gp.ds.Groups[1].Paths[0].Path = broken
+			for i, health := range tc.health {
+				_, err := gp.refreshDevices(context.Background())
+				if (err != nil) != (health == nil) {
+					t.Fatalf("refresh %d: expected error: %t; got %v", i, health == nil, err)
+				}
+				if health == nil {
+					continue
+				}
+				res, _ := gp.listResponse("")
+				var got []string
+				for _, d := range res.Devices {
+					got = append(got, d.Health)
+				}
+				if !slices.Equal(got, health) {
+					t.Errorf("refresh %d: expected health %v; got %v", i, health, got)
+				}
+			}
+			if gp.failures != uint(len(tc.health)) {
+				t.Errorf("expected %d consecutive failures; got %d", len(tc.health), gp.failures)
+			}
+
+			gp.ds.Groups[1].Paths[0].Path = "/dev/ttyACM*"
+			if _, err := gp.refreshDevices(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gp.failures != 0 {
+				t.Errorf("expected failures to be reset; got %d", gp.failures)
+			}
+			if res, _ := gp.listResponse(""); len(res.Devices) != 2 {
+				t.Errorf("expected 2 devices after recovering; got %d", len(res.Devices))
+			}
+		})
+	}
+}
+
+func TestSettle(t *testing.T) {
+	fsys := fstest.MapFS{}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:               "serial",
+		Groups:             []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*"}}}},
+		RemovalGracePeriod: Duration(time.Minute),
+		AppearAfter:        2,
+	}, fsys)
+	now := time.Unix(0, 0)
+	gp.now = func() time.Time { return now }
+
+	for i, step := range []struct {
+		add, remove string
+		elapsed     time.Duration
+		health      []string
+	}{
+		{health: nil},
+		// A new device is held back until it was seen twice.
+		{add: "dev/ttyUSB0", health: nil},
+		{health: []string{v1beta1.Healthy}},
+		// A vanished device is kept as unhealthy during the grace period.
+		{remove: "dev/ttyUSB0", health: []string{v1beta1.Unhealthy}},
+		{elapsed: 30 * time.Second, health: []string{v1beta1.Unhealthy}},
+		// A device that comes back within the grace period is healthy at once.
+		{add: "dev/ttyUSB0", health: []string{v1beta1.Healthy}},
+		// A vanished device is removed after the grace period.
+		{remove: "dev/ttyUSB0", health: []string{v1beta1.Unhealthy}},
+		{elapsed: time.Minute, health: nil},
+		// Flapping devices never appear.
+		{add: "dev/ttyUSB0", health: nil},
+		{remove: "dev/ttyUSB0", health: nil},
+		{add: "dev/ttyUSB0", health: nil},
+		{health: []string{v1beta1.Healthy}},
+	} {
+		if step.add != "" {
+			fsys[step.add] = charDevice
+		}
+		if step.remove != "" {
+			delete(fsys, step.remove)
+		}
+		now = now.Add(step.elapsed)
+		if _, err := gp.refreshDevices(context.Background()); err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		res, _ := gp.listResponse("")
+		var health []string
+		for _, d := range res.Devices {
+			health = append(health, d.Health)
+		}
+		if !slices.Equal(health, step.health) {
+			t.Errorf("step %d: expected health %v; got %v", i, step.health, health)
+		}
+	}
+}
+
+func TestSettleStartup(t *testing.T) {
+	fsys := fstest.MapFS{"dev/ttyUSB0": charDevice}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:        "serial",
+		Groups:      []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*"}}}},
+		AppearAfter: 3,
+	}, fsys)
+	gp.claims = NewClaimRegistry(NoneOverlapPolicy, "", nil)
+	count := func() int {
+		t.Helper()
+		if _, err := gp.refreshDevices(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res, _ := gp.listResponse("")
+		return len(res.Devices)
+	}
+
+	// Devices that are present when the plugin starts are advertised at once.
+	if n := count(); n != 1 {
+		t.Errorf("expected 1 device after the first refresh; got %d", n)
+	}
+	// Later devices are held back.
+	fsys["dev/ttyUSB1"] = charDevice
+	if n := count(); n != 1 {
+		t.Errorf("expected new device to be held back; got %d devices", n)
+	}
+	// Unless they are allocated according to the kubelet checkpoint.
+	fsys["dev/ttyUSB2"] = charDevice
+	devices, err := gp.discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, d := range devices {
+		if d.hostPaths()[0] == "/dev/ttyUSB2" {
+			gp.claims.checkpointed = map[string]map[string]struct{}{gp.ds.Name: {d.ID: {}}}
+		}
+	}
+	if n := count(); n != 2 {
+		t.Errorf("expected checkpointed device to be advertised at once; got %d devices", n)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("expected held back device to appear; got %d devices", n)
+	}
+}
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		out  Duration
+		err  bool
+	}{
+		{data: `"30s"`, out: Duration(30 * time.Second)},
+		{data: `"1m30s"`, out: Duration(90 * time.Second)},
+		{data: `null`},
+		{data: `""`},
+		{data: `"soon"`, err: true},
+	} {
+		var d Duration
+		err := d.UnmarshalJSON([]byte(tc.data))
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error: %t; got %v", tc.data, tc.err, err)
+		}
+		if d != tc.out {
+			t.Errorf("%s: expected %s; got %s", tc.data, tc.out, d)
+		}
+	}
+}
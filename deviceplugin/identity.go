@@ -0,0 +1,226 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	udevDataDir  = "/run/udev/data/"
+	sysDevDir    = "/sys/dev/"
+	usbDevMajor  = 189
+	udevIDSerial = "ID_SERIAL_SHORT"
+	udevIDPath   = "ID_PATH"
+	udevVendorID = "ID_VENDOR_ID"
+	udevModelID  = "ID_MODEL_ID"
+)
+
+// usbPortRegexp matches the name of a USB device's directory in sysfs, e.g. 1-1.2.
+var usbPortRegexp = regexp.MustCompile(`^[0-9]+-[0-9]+(\.[0-9]+)*$`)
+
+// IdentityScheme describes which attribute of the host devices in a group is used to derive device IDs.
+type IdentityScheme string
+
+const (
+	// PathIdentityScheme derives device IDs from the paths of the host devices.
+	// IDs change whenever the paths do, e.g. when a USB device is replugged.
+	PathIdentityScheme IdentityScheme = "path"
+	// SerialIdentityScheme derives device IDs from the USB serial numbers of the host devices.
+	SerialIdentityScheme IdentityScheme = "serial"
+	// PortIdentityScheme derives device IDs from the physical USB ports of the host devices, e.g. 1-1.2.
+	PortIdentityScheme IdentityScheme = "port"
+	// UdevIdentityScheme derives device IDs from the udev ID_PATH property of the host devices.
+	UdevIdentityScheme IdentityScheme = "udev"
+)
+
+// identity holds the attributes of a host device that can be used to identify it.
+// Attributes that cannot be determined are left empty.
+type identity struct {
+	// Path is the path of the device on the host.
+	Path string
+	// Serial is the USB serial number of the device.
+	Serial string
+	// Port is the physical USB port of the device, e.g. 1-1.2.
+	Port string
+	// IDPath is the udev ID_PATH property of the device.
+	IDPath string
+	// Vendor is the USB vendor ID of the device.
+	Vendor string
+	// Product is the USB product ID of the device.
+	Product string
+}
+
+// key returns the attribute of the identity selected by the given scheme.
+// If the attribute is unknown, then it falls back to the path of the device.
+func (i identity) key(scheme IdentityScheme) string {
+	var k string
+	switch scheme {
+	case SerialIdentityScheme:
+		k = i.Serial
+	case PortIdentityScheme:
+		k = i.Port
+	case UdevIdentityScheme:
+		k = i.IDPath
+	}
+	if k == "" {
+		return i.Path
+	}
+	return fmt.Sprintf("%s:%s", scheme, k)
+}
+
+// idTemplateData is the data available to ID templates.
+// The attributes of the first host device in the group are available at the top level.
+type idTemplateData struct {
+	identity
+	// Slot is the index of the device among the Count copies of the group.
+	Slot uint
+	// Index is the index of the match within the group.
+	Index int
+	// Nodes holds the identities of all of the host devices in the group.
+	Nodes []identity
+}
+
+// validate checks that the identity configuration of the group is valid.
+func (g *Group) validate() error {
+	switch g.Identity {
+	case "", PathIdentityScheme, SerialIdentityScheme, PortIdentityScheme, UdevIdentityScheme:
+	default:
+		return fmt.Errorf("unknown identity scheme %q", g.Identity)
+	}
+	if g.IDTemplate == "" {
+		return nil
+	}
+	t, err := template.New("id").Option("missingkey=error").Parse(g.IDTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse ID template: %w", err)
+	}
+	if err := t.Execute(new(bytes.Buffer), idTemplateData{Nodes: []identity{{}}}); err != nil {
+		return fmt.Errorf("failed to execute ID template: %w", err)
+	}
+	g.idTemplate = t
+	return nil
+}
+
+// needsIdentity returns true if device IDs depend on anything but the host paths.
+func (g *Group) needsIdentity() bool {
+	return (g.Identity != "" && g.Identity != PathIdentityScheme) || g.IDTemplate != ""
+}
+
+// deviceID returns the ID of the given copy of a device made up of the given host devices.
+func (g *Group) deviceID(slot uint, index int, nodes []identity) (string, error) {
+	if g.IDTemplate != "" {
+		if g.idTemplate == nil {
+			if err := g.validate(); err != nil {
+				return "", err
+			}
+		}
+		data := idTemplateData{Slot: slot, Index: index, Nodes: nodes}
+		if len(nodes) > 0 {
+			data.identity = nodes[0]
+		}
+		var b strings.Builder
+		if err := g.idTemplate.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("failed to execute ID template: %w", err)
+		}
+		if b.Len() == 0 {
+			return "", fmt.Errorf("ID template %q rendered an empty ID", g.IDTemplate)
+		}
+		return b.String(), nil
+	}
+	h := sha1.New()
+	h.Write([]byte(strconv.FormatUint(uint64(slot), 10)))
+	for _, n := range nodes {
+		h.Write([]byte(n.key(g.Identity)))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// identify looks up the attributes of the device node at the given host path.
+// Attributes that cannot be found are left empty.
+func identify(fsys fs.FS, path string) identity {
+	id := identity{Path: path}
+	fi, err := fs.Stat(fsys, path)
+	if err != nil {
+		return id
+	}
+	major, minor, ok := deviceNumber(fi)
+	if !ok {
+		return id
+	}
+	class := "char"
+	if fi.Mode()&fs.ModeCharDevice == 0 {
+		class = "block"
+	}
+	props, err := udevProperties(fsys, class, major, minor)
+	if err == nil {
+		id.Serial = props[udevIDSerial]
+		id.IDPath = props[udevIDPath]
+		id.Vendor = props[udevVendorID]
+		id.Product = props[udevModelID]
+	}
+	id.Port = usbPort(fsys, class, major, minor)
+	return id
+}
+
+// udevProperties reads the properties that udev recorded for the given device node.
+func udevProperties(fsys fs.FS, class string, major, minor uint32) (map[string]string, error) {
+	data, err := fs.ReadFile(fsys, filepath.Join(udevDataDir, fmt.Sprintf("%c%d:%d", class[0], major, minor)))
+	if err != nil {
+		return nil, err
+	}
+	props := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		kv, ok := strings.CutPrefix(s.Text(), "E:")
+		if !ok {
+			continue
+		}
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			props[k] = v
+		}
+	}
+	return props, s.Err()
+}
+
+// usbPort returns the physical USB port of the given device node
+// by finding the closest USB device in the node's sysfs path.
+func usbPort(fsys fs.FS, class string, major, minor uint32) string {
+	target, err := fs.ReadLink(fsys, filepath.Join(sysDevDir, class, fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(target, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if usbPortRegexp.MatchString(parts[i]) {
+			return parts[i]
+		}
+	}
+	return ""
+}
+
+// usbMinor returns the minor number of the device node of the USB device at the given bus and device number.
+func usbMinor(bus, busDevice uint16) uint32 {
+	return (uint32(bus)-1)*128 + uint32(busDevice) - 1
+}
@@ -0,0 +1,178 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/squat/generic-device-plugin/absolute"
+)
+
+func TestDeviceID(t *testing.T) {
+	before := []identity{{Path: "/dev/bus/usb/003/022", Serial: "51", Port: "3-4"}}
+	replugged := []identity{{Path: "/dev/bus/usb/003/023", Serial: "51", Port: "3-4"}}
+	moved := []identity{{Path: "/dev/bus/usb/001/007", Serial: "51", Port: "1-1.2"}}
+	for _, tc := range []struct {
+		name      string
+		group     *Group
+		a, b      []identity
+		slotB     uint
+		equal     bool
+		expectedA string
+	}{
+		{
+			name:  "path changes on replug",
+			group: &Group{Identity: PathIdentityScheme},
+			a:     before,
+			b:     replugged,
+			equal: false,
+		},
+		{
+			name:  "serial survives replug",
+			group: &Group{Identity: SerialIdentityScheme},
+			a:     before,
+			b:     moved,
+			equal: true,
+		},
+		{
+			name:  "port survives replug",
+			group: &Group{Identity: PortIdentityScheme},
+			a:     before,
+			b:     replugged,
+			equal: true,
+		},
+		{
+			name:  "port changes when moved",
+			group: &Group{Identity: PortIdentityScheme},
+			a:     before,
+			b:     moved,
+			equal: false,
+		},
+		{
+			name:  "slots differ",
+			group: &Group{Identity: SerialIdentityScheme},
+			a:     before,
+			b:     before,
+			slotB: 1,
+			equal: false,
+		},
+		{
+			name:      "template",
+			group:     &Group{IDTemplate: "usb-{{.Serial}}-{{.Slot}}"},
+			a:         before,
+			b:         moved,
+			equal:     true,
+			expectedA: "usb-51-0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.group.validate(); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+			a, err := tc.group.deviceID(0, 0, tc.a)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := tc.group.deviceID(tc.slotB, 0, tc.b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (a == b) != tc.equal {
+				t.Errorf("expected IDs %q and %q to be equal: %t", a, b, tc.equal)
+			}
+			if tc.expectedA != "" && a != tc.expectedA {
+				t.Errorf("expected ID %q; got %q", tc.expectedA, a)
+			}
+		})
+	}
+}
+
+func TestGroupValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		group *Group
+		err   bool
+	}{
+		{
+			name:  "empty",
+			group: &Group{},
+		},
+		{
+			name:  "unknown scheme",
+			group: &Group{Identity: "mac"},
+			err:   true,
+		},
+		{
+			name:  "malformed template",
+			group: &Group{IDTemplate: "{{.Serial"},
+			err:   true,
+		},
+		{
+			name:  "unknown template field",
+			group: &Group{IDTemplate: "{{.Mac}}"},
+			err:   true,
+		},
+		{
+			name:  "template with nodes",
+			group: &Group{IDTemplate: `{{range .Nodes}}{{.Port}}{{end}}`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.group.validate(); (err != nil) != tc.err {
+				t.Errorf("expected error: %t; got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestIdentifyUSB(t *testing.T) {
+	fsys := absolute.New(fstest.MapFS{
+		"run/udev/data/c189:277": {Data: []byte("I:123\nE:ID_PATH=pci-0000:00:14.0-usb-0:4\nE:ID_SERIAL_SHORT=51\n")},
+		"sys/dev/char/188:0":     {Data: []byte("../../devices/pci0000:00/0000:00:14.0/usb3/3-4/3-4:1.0/ttyUSB0/tty/ttyUSB0"), Mode: fs.ModeSymlink},
+	}, "/")
+	dev := usbDevice{Vendor: 0x1050, Product: 0x0407, Bus: 3, BusDevice: 22, Serial: "51", Port: "3-4"}
+	id := dev.identity(fsys, true)
+	if id.IDPath != "pci-0000:00:14.0-usb-0:4" {
+		t.Errorf("expected ID_PATH %q; got %q", "pci-0000:00:14.0-usb-0:4", id.IDPath)
+	}
+	if port := usbPort(fsys, "char", 188, 0); port != "3-4" {
+		t.Errorf("expected port %q; got %q", "3-4", port)
+	}
+}
+
+func TestDropCollisions(t *testing.T) {
+	gp := &GenericPlugin{
+		logger:              log.NewNopLogger(),
+		idCollisionsCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test"}),
+	}
+	devices := []device{
+		{Device: &v1beta1.Device{ID: "a"}, deviceSpecs: []*v1beta1.DeviceSpec{{HostPath: "/dev/ttyUSB0"}}},
+		{Device: &v1beta1.Device{ID: "b"}, deviceSpecs: []*v1beta1.DeviceSpec{{HostPath: "/dev/ttyUSB1"}}},
+		{Device: &v1beta1.Device{ID: "a"}, deviceSpecs: []*v1beta1.DeviceSpec{{HostPath: "/dev/ttyUSB2"}}},
+	}
+	out := gp.dropCollisions(devices)
+	if len(out) != 2 {
+		t.Fatalf("expected 2 devices; got %d", len(out))
+	}
+	if out[0].deviceSpecs[0].HostPath != "/dev/ttyUSB0" {
+		t.Errorf("expected the first device to win; got %q", out[0].deviceSpecs[0].HostPath)
+	}
+}
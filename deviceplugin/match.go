@@ -0,0 +1,251 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// MatchMode decides how the Path of a Path is matched against the paths in the host's file system.
+type MatchMode string
+
+const (
+	// GlobMatchMode matches paths with the syntax of path.Match, e.g. /dev/ttyUSB*.
+	GlobMatchMode MatchMode = "glob"
+	// DoublestarMatchMode additionally matches zero or more directories with **, e.g. /dev/dri/**/card*;
+	// a trailing ** matches everything below a directory, e.g. /dev/dri/by-path/**.
+	DoublestarMatchMode MatchMode = "doublestar"
+	// RegexMatchMode matches entire paths with a regular expression, e.g. /dev/video[0-9]+.
+	// All paths below the longest directory that is a literal prefix of the expression are searched,
+	// so the expression must start with a literal directory other than /.
+	RegexMatchMode MatchMode = "regex"
+)
+
+// MatchModes contains all of the possible match modes.
+var MatchModes = []MatchMode{
+	GlobMatchMode,
+	DoublestarMatchMode,
+	RegexMatchMode,
+}
+
+// doublestar is the path segment that matches zero or more directories.
+const doublestar = "**"
+
+// find returns the paths in the file system that match the given pattern in the given mode.
+// Walking the file system stops when the given context is done.
+func find(ctx context.Context, fsys fs.FS, mode MatchMode, pattern string) ([]string, error) {
+	switch mode {
+	case "", GlobMatchMode:
+		return fs.Glob(fsys, pattern)
+	case DoublestarMatchMode:
+		if err := validateDoublestar(pattern); err != nil {
+			return nil, err
+		}
+		segments := strings.Split(pattern, "/")
+		// Only walk the directory below which the first wildcard appears.
+		root := "/"
+		for i, s := range segments {
+			if hasMeta(s) {
+				root = "/" + path.Join(segments[:i]...)
+				break
+			}
+		}
+		if !hasMeta(pattern) {
+			root = pattern
+		}
+		return walk(ctx, fsys, root, func(name string, dir bool) (bool, bool) {
+			s := strings.Split(name, "/")
+			return matchSegments(segments, s, false), dir && matchSegments(segments, s, true)
+		})
+	case RegexMatchMode:
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		root, err := regexRoot(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return walk(ctx, fsys, root, func(name string, dir bool) (bool, bool) {
+			return re.MatchString(name), dir
+		})
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", mode)
+	}
+}
+
+// matcher returns a function that reports whether a path matches the given pattern in the given mode.
+func matcher(mode MatchMode, pattern string) (func(string) bool, error) {
+	switch mode {
+	case "", GlobMatchMode:
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", pattern, err)
+		}
+		return func(name string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}, nil
+	case DoublestarMatchMode:
+		if err := validateDoublestar(pattern); err != nil {
+			return nil, err
+		}
+		segments := strings.Split(pattern, "/")
+		return func(name string) bool {
+			return matchSegments(segments, strings.Split(name, "/"), false)
+		}, nil
+	case RegexMatchMode:
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", mode)
+	}
+}
+
+// compileRegexp compiles a regular expression that must match entire paths.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// regexRoot returns the longest directory that is a literal prefix of the given regular expression,
+// below which all of its matches are found, or / if the expression does not start with a literal directory.
+func regexRoot(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %q: %w", pattern, err)
+	}
+	prefix, _ := literalPrefix(re)
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		return prefix[:i], nil
+	}
+	return "/", nil
+}
+
+// literalPrefix returns the literal text with which every match of the given expression starts
+// and whether the expression matches only that text.
+func literalPrefix(re *syntax.Regexp) (string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return "", false
+		}
+		return string(re.Rune), true
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpBeginLine:
+		return "", true
+	case syntax.OpCapture:
+		return literalPrefix(re.Sub[0])
+	case syntax.OpConcat:
+		var b strings.Builder
+		for _, sub := range re.Sub {
+			prefix, complete := literalPrefix(sub)
+			b.WriteString(prefix)
+			if !complete {
+				return b.String(), false
+			}
+		}
+		return b.String(), true
+	default:
+		return "", false
+	}
+}
+
+// validateDoublestar checks that every segment of the given pattern is valid.
+func validateDoublestar(pattern string) error {
+	for _, s := range strings.Split(pattern, "/") {
+		if _, err := path.Match(s, ""); err != nil {
+			return fmt.Errorf("failed to parse %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// hasMeta reports whether the given pattern contains any of the special characters of path.Match.
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// matchSegments reports whether the segments of a path match the segments of a doublestar pattern.
+// If prefix is true, it reports whether a path below the given path could match.
+func matchSegments(pattern, segments []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == doublestar {
+			if prefix {
+				return true
+			}
+			// A trailing ** matches everything below a directory but not the directory itself.
+			if len(pattern) == 1 {
+				return len(segments) > 0
+			}
+			for i := range len(segments) + 1 {
+				if matchSegments(pattern[1:], segments[i:], false) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return prefix
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// walk returns the paths below the given root for which the given function reports a match.
+// The function also decides whether to descend into directories.
+// Symbolic links are not followed, except for the root, and unreadable directories are skipped.
+// Walking stops with the error of the given context when the context is done.
+func walk(ctx context.Context, fsys fs.FS, root string, f func(name string, dir bool) (match, descend bool)) ([]string, error) {
+	var matches []string
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if name == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			if name == root {
+				return err
+			}
+			return nil
+		}
+		match, descend := f(name, d.IsDir())
+		if match {
+			matches = append(matches, name)
+		}
+		if d.IsDir() && !descend {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return matches, err
+}
@@ -0,0 +1,168 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/squat/generic-device-plugin/absolute"
+)
+
+func TestFind(t *testing.T) {
+	fsys := absolute.New(fstest.MapFS{
+		"dev/dri/card0":                           {},
+		"dev/dri/renderD128":                      {},
+		"dev/dri/by-path/pci-0000:00:02.0-card":   {Mode: fs.ModeSymlink, Data: []byte("../card0")},
+		"dev/dri/by-path/pci-0000:00:02.0-render": {Mode: fs.ModeSymlink, Data: []byte("../renderD128")},
+		"dev/video0":                              {},
+		"dev/video1":                              {},
+		"dev/video10":                             {},
+		"dev/videos/card":                         {},
+		"sys/class/drm/card0":                     {},
+	}, "/")
+	for _, tc := range []struct {
+		name    string
+		mode    MatchMode
+		pattern string
+		out     []string
+		err     bool
+	}{
+		{
+			name:    "glob",
+			mode:    GlobMatchMode,
+			pattern: "/dev/video*",
+			out:     []string{"/dev/video0", "/dev/video1", "/dev/video10", "/dev/videos"},
+		},
+		{
+			name:    "doublestar matches zero or more directories",
+			mode:    DoublestarMatchMode,
+			pattern: "/dev/**/card*",
+			out:     []string{"/dev/dri/card0", "/dev/videos/card"},
+		},
+		{
+			name:    "trailing doublestar matches everything below a directory",
+			mode:    DoublestarMatchMode,
+			pattern: "/dev/dri/by-path/**",
+			out:     []string{"/dev/dri/by-path/pci-0000:00:02.0-card", "/dev/dri/by-path/pci-0000:00:02.0-render"},
+		},
+		{
+			name:    "doublestar without wildcards",
+			mode:    DoublestarMatchMode,
+			pattern: "/dev/dri/card0",
+			out:     []string{"/dev/dri/card0"},
+		},
+		{
+			name:    "doublestar in a missing directory",
+			mode:    DoublestarMatchMode,
+			pattern: "/dev/missing/**",
+		},
+		{
+			name:    "malformed doublestar",
+			mode:    DoublestarMatchMode,
+			pattern: "/dev/[/**",
+			err:     true,
+		},
+		{
+			name:    "regex",
+			mode:    RegexMatchMode,
+			pattern: "/dev/video[0-9]+",
+			out:     []string{"/dev/video0", "/dev/video1", "/dev/video10"},
+		},
+		{
+			name:    "regex with alternation",
+			mode:    RegexMatchMode,
+			pattern: "/dev/(dri/card|video)[0-9]",
+			out:     []string{"/dev/dri/card0", "/dev/video0", "/dev/video1"},
+		},
+		{
+			name:    "malformed regex",
+			mode:    RegexMatchMode,
+			pattern: "/dev/video(",
+			err:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := find(context.Background(), fsys, tc.mode, tc.pattern)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t; got %v", tc.err, err)
+			}
+			slices.Sort(out)
+			if !slices.Equal(out, tc.out) {
+				t.Errorf("expected %v; got %v", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestRegexRoot(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		root    string
+	}{
+		{pattern: "/dev/video[0-9]+", root: "/dev"},
+		{pattern: "/dev/dri/(card|renderD)[0-9]+", root: "/dev/dri"},
+		{pattern: "/dev/.*video.*[0-9]", root: "/dev"},
+		{pattern: "/dev/bus/usb/(?i)abc", root: "/dev/bus/usb"},
+		{pattern: "(/dev|/run)/video[0-9]+", root: "/"},
+		{pattern: "/video[0-9]+", root: "/"},
+	} {
+		root, err := regexRoot(tc.pattern)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.pattern, err)
+		}
+		if root != tc.root {
+			t.Errorf("%q: expected %q; got %q", tc.pattern, tc.root, root)
+		}
+	}
+}
+
+func TestFindCancel(t *testing.T) {
+	fsys := absolute.New(fstest.MapFS{"dev/video0": {}}, "/")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := find(ctx, fsys, RegexMatchMode, "/dev/video[0-9]+"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v; got %v", context.Canceled, err)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		mode    MatchMode
+		pattern string
+		name    string
+		match   bool
+	}{
+		{mode: GlobMatchMode, pattern: "/dev/video*[13579]", name: "/dev/video1", match: true},
+		{mode: GlobMatchMode, pattern: "/dev/video*[13579]", name: "/dev/video10"},
+		{mode: DoublestarMatchMode, pattern: "/dev/**/render*", name: "/dev/dri/renderD128", match: true},
+		{mode: DoublestarMatchMode, pattern: "/dev/**/render*", name: "/dev/renderD128", match: true},
+		{mode: DoublestarMatchMode, pattern: "/dev/**", name: "/dev"},
+		{mode: RegexMatchMode, pattern: "/dev/video[0-9]", name: "/dev/video1", match: true},
+		{mode: RegexMatchMode, pattern: "/dev/video[0-9]", name: "/dev/video10"},
+	} {
+		m, err := matcher(tc.mode, tc.pattern)
+		if err != nil {
+			t.Fatalf("%s %q: unexpected error: %v", tc.mode, tc.pattern, err)
+		}
+		if m(tc.name) != tc.match {
+			t.Errorf("%s %q: expected %q to match %t", tc.mode, tc.pattern, tc.name, tc.match)
+		}
+	}
+}
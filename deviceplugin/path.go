@@ -15,27 +15,63 @@
 package deviceplugin
 
 import (
-	"crypto/sha1"
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"math"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
+// maxSymlinks is the maximum number of symbolic links followed when resolving a path.
+const maxSymlinks = 40
+
 // Path represents a file path that should be discovered.
 type Path struct {
 	// Path is the file path of a device in the host.
+	// Path can contain correlation keys in braces, e.g. /dev/snd/controlC{card}, which match like * but
+	// join the matches of the paths in a group on the values of the keys instead of their sorted positions.
+	// All paths with correlation keys in a group must use the same keys and cannot have a Limit.
+	// Path must be empty if Device is specified.
 	Path string `json:"path"`
+	// Device selects device nodes by their device numbers and kernel drivers instead of by Path,
+	// e.g. all nodes with major number 166 or all nodes whose device is bound to the cdc_acm driver.
+	// Device nodes are listed in /sys/dev/char and /sys/dev/block and resolved to their paths in /dev
+	// through the DEVNAME in their uevent files.
+	Device *DeviceSelector `json:"device,omitempty"`
+	// Match decides how Path is matched against the paths in the host's file system.
+	// This can be one of:
+	// * glob - Path is a glob, e.g. /dev/ttyUSB*;
+	// * doublestar - Path is a glob in which ** matches zero or more directories, e.g. /dev/dri/by-path/**; or
+	// * regex - Path is a regular expression matching entire paths, e.g. /dev/video[0-9]+,
+	//   whose named groups are correlation keys.
+	// When unspecified, Match defaults to glob.
+	Match MatchMode `json:"match,omitempty"`
+	// Exclude is a list of patterns in the same match mode as Path.
+	// Paths matching any of the patterns are not matched by Path, e.g. /dev/video*[13579].
+	Exclude []string `json:"exclude,omitempty"`
 	// MountPath is the file path at which the host device should be mounted within the container.
+	// MountPath is a Go template, which can use the fields .Path, the matched host path, .Target,
+	// the host path after resolving symbolic links, .Base, the base name of .Target, .Captures,
+	// the matched host path followed by the values captured by every wildcard or regular expression group,
+	// .Keys, the values of the correlation keys by name, .Index, the index of the match within the group,
+	// and .Slot, the copy of the group when Count is greater than 1,
+	// e.g. "/dev/serial/port{{index .Captures 1}}" for the regular expression /dev/ttyUSB([0-9]+).
+	// If MountPath ends with a slash, the base name of the host device is appended to it.
 	// When unspecified, MountPath defaults to the Path.
 	MountPath string `json:"mountPath,omitempty"`
 	// Permissions is the file-system permissions given to the mounted device.
-	// Permissions apply only to mounts of type `Device`.
+	// Permissions apply only to paths of the types `Device`, `CharDevice`, and `BlockDevice`.
 	// This can be one or more of:
 	// * r - allows the container to read from the specified device.
 	// * w - allows the container to write to the specified device.
@@ -43,9 +79,19 @@ type Path struct {
 	// When unspecified, Permissions defaults to mrw.
 	Permissions string `json:"permissions,omitempty"`
 	// ReadOnly specifies whether the path should be mounted read-only.
-	// ReadOnly applies only to mounts of type `Mount`.
+	// ReadOnly applies only to paths that are bind-mounted, i.e. of all other types.
 	ReadOnly bool `json:"readOnly,omitempty"`
 	// Type describes what type of file-system node this Path represents and thus how it should be mounted.
+	// This can be one of:
+	// * Device - a character or block device node or a directory of device nodes, mounted as a device.
+	// * CharDevice - a character device node, mounted as a device.
+	// * BlockDevice - a block device node, mounted as a device.
+	// * Mount - any file-system node, bind-mounted without checking its type.
+	// * Directory - a directory, bind-mounted.
+	// * DirectoryOrCreate - a directory that is created on the host if it does not exist, bind-mounted.
+	// * File - a regular file, bind-mounted.
+	// * Socket - a UNIX socket, bind-mounted.
+	// Matches of any other type are skipped.
 	// When unspecified, Type defaults to Device.
 	Type PathType `json:"type"`
 	// Limit specifies up to how many times this device can be used in the group concurrently when other devices
@@ -59,96 +105,613 @@ type Path struct {
 	// This allows containers to start even when some devices are not present on the system.
 	// When unspecified, Optional defaults to false.
 	Optional bool `json:"optional,omitempty"`
+	// KeepLinks specifies whether a host device that was matched through a symbolic link,
+	// e.g. /dev/serial/by-id/usb-FTDI_*, should additionally be exposed in the container at the path of the link.
+	// Matches are always resolved to the real device node, which is mounted at MountPath or,
+	// when MountPath is unspecified, at the path of the real device node.
+	// Since the device plugin API cannot create symbolic links, the link is recreated as a device node.
+	// When unspecified, KeepLinks defaults to false.
+	KeepLinks bool `json:"keepLinks,omitempty"`
+
+	mountPathTemplate *template.Template
+	// sysfs is the SysfsSpec that selects the device nodes of the path, if the path was made from one.
+	sysfs *SysfsSpec
 }
 
 // PathType represents the kinds of file-system nodes that can be scheduled.
+// Matches whose nodes are not of the type of their Path are skipped during discovery.
 type PathType string
 
 const (
-	// DevicePathType represents a file-system device node and is mounted as a device.
+	// DevicePathType represents a character or block device node, or a directory of device nodes,
+	// e.g. /dev/snd, which the container runtime expands into the nodes it contains, and is mounted as a device.
 	DevicePathType PathType = "Device"
-	// MountPathType represents an ordinary file-system node and is bind-mounted.
+	// CharDevicePathType represents a character device node and is mounted as a device.
+	CharDevicePathType PathType = "CharDevice"
+	// BlockDevicePathType represents a block device node and is mounted as a device.
+	BlockDevicePathType PathType = "BlockDevice"
+	// MountPathType represents any file-system node and is bind-mounted.
 	MountPathType PathType = "Mount"
+	// DirectoryPathType represents a directory and is bind-mounted.
+	DirectoryPathType PathType = "Directory"
+	// DirectoryOrCreatePathType represents a directory that is created if it does not exist and is bind-mounted.
+	// The directory is created on the host with permissions 0755 when the devices are discovered,
+	// so its Path must not contain wildcards.
+	DirectoryOrCreatePathType PathType = "DirectoryOrCreate"
+	// FilePathType represents a regular file and is bind-mounted.
+	FilePathType PathType = "File"
+	// SocketPathType represents a UNIX socket and is bind-mounted.
+	SocketPathType PathType = "Socket"
 )
 
-func (gp *GenericPlugin) discoverPath() ([]device, error) {
+// PathTypes contains all of the possible path types.
+var PathTypes = []PathType{
+	DevicePathType,
+	CharDevicePathType,
+	BlockDevicePathType,
+	MountPathType,
+	DirectoryPathType,
+	DirectoryOrCreatePathType,
+	FilePathType,
+	SocketPathType,
+}
+
+// errWrongType is returned for nodes that are not of the type of the path that matched them.
+var errWrongType = errors.New("wrong type of file-system node")
+
+// isDevice reports whether nodes of the type are mounted as devices rather than bind-mounted.
+func (t PathType) isDevice() bool {
+	switch t {
+	case DevicePathType, CharDevicePathType, BlockDevicePathType:
+		return true
+	}
+	return false
+}
+
+// check returns an error wrapping errWrongType if a node with the given mode is not of the type.
+func (t PathType) check(mode fs.FileMode) error {
+	var ok bool
+	switch t {
+	case DevicePathType:
+		ok = mode&fs.ModeDevice != 0 || mode.IsDir()
+	case CharDevicePathType:
+		ok = mode&fs.ModeDevice != 0 && mode&fs.ModeCharDevice != 0
+	case BlockDevicePathType:
+		ok = mode&fs.ModeDevice != 0 && mode&fs.ModeCharDevice == 0
+	case DirectoryPathType, DirectoryOrCreatePathType:
+		ok = mode.IsDir()
+	case FilePathType:
+		ok = mode.IsRegular()
+	case SocketPathType:
+		ok = mode&fs.ModeSocket != 0
+	default:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("%w: expected %s, found %s", errWrongType, t, describeMode(mode))
+	}
+	return nil
+}
+
+// describeMode returns the kind of file-system node with the given mode.
+func describeMode(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeDevice != 0 && mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "block device"
+	case mode.IsDir():
+		return "directory"
+	case mode.IsRegular():
+		return "regular file"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	default:
+		return "irregular file"
+	}
+}
+
+// match is a host file-system node matched by a Path.
+type match struct {
+	// path is the path that matched.
+	path string
+	// target is the path of the node after resolving all symbolic links.
+	target string
+	// captures holds the path followed by the values captured by the wildcards and keys of the pattern.
+	captures []string
+	// keys holds the values of the correlation keys by their names.
+	keys map[string]string
+}
+
+// name describes the path, e.g. in logs and metrics.
+func (p *Path) name() string {
+	if p.Device != nil {
+		return p.Device.String()
+	}
+	if p.sysfs != nil {
+		return p.sysfs.String()
+	}
+	return p.Path
+}
+
+// validate checks that the patterns of the path are valid.
+func (p *Path) validate() error {
+	if p.Device != nil {
+		if p.Path != "" {
+			return fmt.Errorf("path %q cannot also have a device selector", p.Path)
+		}
+		if p.Type != "" && !p.Type.isDevice() {
+			return fmt.Errorf("device selector cannot select paths of type %s", p.Type)
+		}
+		if err := p.Device.validate(); err != nil {
+			return err
+		}
+	}
+	if p.Type != "" && !slices.Contains(PathTypes, p.Type) {
+		return fmt.Errorf("unknown path type %q", p.Type)
+	}
+	if p.Type == DirectoryOrCreatePathType && (p.Match == RegexMatchMode || hasMeta(keyRegexp.ReplaceAllString(p.Path, "*"))) {
+		return fmt.Errorf("path %q of type %s must not contain wildcards", p.Path, p.Type)
+	}
+	switch p.Match {
+	case "", GlobMatchMode, DoublestarMatchMode, RegexMatchMode:
+	default:
+		return fmt.Errorf("unknown match mode %q", p.Match)
+	}
+	if _, err := parsePattern(p.Match, p.Path); err != nil {
+		return err
+	}
+	if p.Match == RegexMatchMode && p.Path != "" {
+		if root, err := regexRoot(p.Path); err != nil {
+			return err
+		} else if root == "/" {
+			return fmt.Errorf("regex %q must start with a literal directory other than /, e.g. /dev/", p.Path)
+		}
+	}
+	if _, err := p.excluded(); err != nil {
+		return err
+	}
+	return p.parseMountPath()
+}
+
+// mountPathData is the data available to MountPath templates.
+type mountPathData struct {
+	Path     string
+	Target   string
+	Base     string
+	Captures []string
+	Keys     map[string]string
+	Index    int
+	Slot     uint
+}
+
+// parseMountPath parses the MountPath template of the path.
+func (p *Path) parseMountPath() error {
+	t, err := template.New("mountPath").Option("missingkey=error").Parse(p.MountPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse mount path template: %w", err)
+	}
+	p.mountPathTemplate = t
+	return nil
+}
+
+// containerPath returns the path at which the given match of the path is mounted
+// in the given copy of the device with the given index within its group.
+func (p *Path) containerPath(m match, index int, slot uint) (string, error) {
+	if p.mountPathTemplate == nil {
+		if err := p.parseMountPath(); err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	if err := p.mountPathTemplate.Execute(&b, mountPathData{
+		Path:     m.path,
+		Target:   m.target,
+		Base:     filepath.Base(m.target),
+		Captures: m.captures,
+		Keys:     m.keys,
+		Index:    index,
+		Slot:     slot,
+	}); err != nil {
+		return "", fmt.Errorf("failed to execute mount path template for %q: %w", m.path, err)
+	}
+	mountPath := b.String()
+	if mountPath == "" {
+		mountPath = m.target
+	}
+	if strings.HasSuffix(mountPath, "/") {
+		mountPath = mountPath + filepath.Base(m.target)
+	}
+	return mountPath, nil
+}
+
+// excluded returns a function that reports whether a matched path is excluded.
+func (p *Path) excluded() (func(string) bool, error) {
+	matchers := make([]func(string) bool, 0, len(p.Exclude))
+	for _, e := range p.Exclude {
+		m, err := matcher(p.Match, e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+		matchers = append(matchers, m)
+	}
+	return func(name string) bool {
+		for _, m := range matchers {
+			if m(name) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// pathExplanation explains how often the matches of a path can be used in its group.
+type pathExplanation struct {
+	path     string
+	matches  int
+	limit    uint
+	optional bool
+}
+
+// groupExplanation explains how many devices a group of paths yields.
+type groupExplanation struct {
+	paths []pathExplanation
+	// length is the number of distinct combinations of host devices in the group.
+	length int
+	// cappedBy is the index of the path that capped the length, or -1 if the length was not capped.
+	cappedBy int
+	// devices is the number of devices yielded by the group, i.e. the length times the count of the group.
+	devices int
+}
+
+func (e groupExplanation) equal(o groupExplanation) bool {
+	return slices.Equal(e.paths, o.paths) && e.length == o.length && e.cappedBy == o.cappedBy && e.devices == o.devices
+}
+
+// cappedByPath returns the path that capped the length of the group, if any.
+func (e groupExplanation) cappedByPath() string {
+	if e.cappedBy < 0 {
+		return ""
+	}
+	return e.paths[e.cappedBy].path
+}
+
+func (e groupExplanation) String() string {
+	parts := make([]string, 0, len(e.paths))
+	for _, p := range e.paths {
+		switch {
+		case p.optional && p.matches == 0:
+			parts = append(parts, fmt.Sprintf("%s: no matches, ignored", p.path))
+		default:
+			parts = append(parts, fmt.Sprintf("%s: %d matches, limit %d", p.path, p.matches, p.limit))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// explain records how many devices the group with the given index yields.
+// Changes are logged and exposed as metrics, so that it can be told which path limits a group.
+func (gp *GenericPlugin) explain(gi int, e groupExplanation) {
+	if old, ok := gp.explanations[gi]; ok && old.equal(e) {
+		return
+	}
+	gp.explanations[gi] = e
+	group := strconv.Itoa(gi)
+	_ = level.Debug(gp.logger).Log("msg", "group cardinality changed", "group", group, "length", e.length, "devices", e.devices, "cappedBy", e.cappedByPath(), "paths", e.String())
+	gp.groupDevices.WithLabelValues(group).Set(float64(e.devices))
+	gp.groupCappedBy.DeletePartialMatch(prometheus.Labels{"group": group})
+	if path := e.cappedByPath(); path != "" {
+		gp.groupCappedBy.WithLabelValues(group, path).Set(1)
+	}
+}
+
+// joinKeys returns the values of the correlation keys that all of the required paths
+// of the group with correlation keys matched, in their natural order,
+// and the index of the required path with the fewest matches, which limits the join.
+func joinKeys(group *Group, keyed []map[string]match, values map[string][]string) ([][]string, int) {
+	joined := slices.SortedFunc(maps.Values(values), compareKeys)
+	cappedBy := -1
+	for i, m := range keyed {
+		if m == nil || group.Paths[i].Optional {
+			continue
+		}
+		if cappedBy < 0 || len(m) < len(keyed[cappedBy]) {
+			cappedBy = i
+		}
+		joined = slices.DeleteFunc(joined, func(v []string) bool {
+			_, ok := m[strings.Join(v, "/")]
+			return !ok
+		})
+	}
+	return joined, cappedBy
+}
+
+// resolveSymlinks returns the path of the node at the given path after resolving all symbolic links.
+func resolveSymlinks(fsys fs.FS, path string) (string, error) {
+	for range maxSymlinks {
+		fi, err := fs.Lstat(fsys, path)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := fs.ReadLink(fsys, path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("failed to resolve %q: too many levels of symbolic links", path)
+}
+
+// checkType returns an error if the node at the given path is not of the given type.
+func checkType(fsys fs.FS, path string, t PathType) error {
+	if t == MountPathType {
+		return nil
+	}
+	fi, err := fs.Stat(fsys, path)
+	if err != nil {
+		return err
+	}
+	return t.check(fi.Mode())
+}
+
+// createDirectory creates the directory at the given host path and any missing parents,
+// unless the path already exists.
+func (gp *GenericPlugin) createDirectory(path string) error {
+	if _, err := fs.Stat(gp.fs, path); !errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := gp.mkdirAll(path, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", path, err)
+	}
+	_ = level.Info(gp.logger).Log("msg", "created directory", "path", path)
+	return nil
+}
+
+// discoverPath discovers the devices of all groups with paths or sysfs specs.
+// Groups that fail are skipped and their errors are returned
+// along with the devices of the other groups.
+func (gp *GenericPlugin) discoverPath(ctx context.Context) ([]device, error) {
 	var devices []device
-	var mountPath string
-	for _, group := range gp.ds.Groups {
-		paths := make([][]string, len(group.Paths))
+	var errs []error
+	// produced holds the host nodes of the devices of all previous groups, so that groups
+	// matching the same nodes, e.g. through different symbolic links, do not yield the same device twice.
+	produced := make(map[string]struct{})
+	// wrongType holds the matches that were skipped because they are not of the type of their path.
+	var wrongType []diagnostic
+Groups:
+	for gi, group := range gp.ds.Groups {
+		var groupDevices []device
+		groupPaths := group.paths()
+		// Track the resolved nodes so that every node is matched at most once by the paths of a group;
+		// other groups can use the same nodes, e.g. a shared /dev/snd/seq.
+		seen := make(map[string]struct{})
+		paths := make([][]match, len(groupPaths))
 		var length int
 		limitLength := math.MaxInt
+		explanation := groupExplanation{paths: make([]pathExplanation, len(groupPaths)), cappedBy: -1}
+		limitPath := -1
 		// Track which paths have matches (used for optional paths).
-		pathHasMatches := make([]bool, len(group.Paths))
+		pathHasMatches := make([]bool, len(groupPaths))
+		// keyed holds the matches of the paths with correlation keys by the values of their keys.
+		keyed := make([]map[string]match, len(groupPaths))
+		// values holds the values of all of the correlation keys that were matched.
+		values := make(map[string][]string)
 		// Discover all the devices matching each pattern in the Paths group.
-		for i, path := range group.Paths {
-			matches, err := fs.Glob(gp.fs, path.Path)
+		for i, path := range groupPaths {
+			pt, err := parsePattern(path.Match, path.Path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+				continue Groups
+			}
+			excluded, err := path.excluded()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+				continue Groups
+			}
+			if path.Type == DirectoryOrCreatePathType {
+				if err := gp.createDirectory(path.Path); err != nil {
+					errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+					continue Groups
+				}
+			}
+			var globs []string
+			switch {
+			case path.Device != nil:
+				globs, err = gp.selectDevices(ctx, path.Device)
+			case path.sysfs != nil:
+				globs, err = gp.selectSysfs(ctx, path.sysfs)
+			default:
+				globs, err = gp.find(ctx, path.Match, pt.search)
+			}
 			if err != nil {
-				return nil, err
+				errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+				continue Groups
+			}
+			sort.Strings(globs)
+			if len(pt.keys) > 0 {
+				keyed[i] = make(map[string]match)
+			}
+			matches := make([]match, 0, len(globs))
+			for _, g := range globs {
+				if excluded(g) {
+					continue
+				}
+				captures, keys, ok := pt.captures(g)
+				if !ok {
+					if keyed[i] != nil {
+						// Keys match one or more characters, while the wildcards searched for them also match none.
+						continue
+					}
+					captures = []string{g}
+				}
+				var key []string
+				if keyed[i] != nil {
+					key = keyOf(keys)
+					if _, ok := keyed[i][strings.Join(key, "/")]; ok {
+						_ = level.Debug(gp.logger).Log("msg", "skipping path with duplicate correlation key", "path", g, "key", strings.Join(key, "/"))
+						continue
+					}
+				}
+				target, err := resolveSymlinks(gp.fs, g)
+				if err != nil {
+					_ = level.Debug(gp.logger).Log("msg", "skipping unresolvable path", "path", g, "err", err)
+					continue
+				}
+				if _, ok := seen[target]; ok {
+					continue
+				}
+				if err := checkType(gp.fs, target, path.Type); err != nil {
+					wrongType = append(wrongType, diagnostic{source: pathSource, path: g, reason: skipReason(err), err: err})
+					continue
+				}
+				seen[target] = struct{}{}
+				m := match{path: g, target: target, captures: captures, keys: keys}
+				matches = append(matches, m)
+				if keyed[i] != nil {
+					keyed[i][strings.Join(key, "/")] = m
+					values[strings.Join(key, "/")] = key
+				}
 			}
+			explanation.paths[i] = pathExplanation{path: path.name(), matches: len(matches), limit: path.Limit, optional: path.Optional}
 			// If no matches found and path is optional, skip it.
 			if len(matches) == 0 && path.Optional {
 				continue
 			}
 			pathHasMatches[i] = true
-			sort.Strings(matches)
+			if keyed[i] != nil {
+				// Paths with correlation keys are joined on the values of their keys below.
+				continue
+			}
 			for j := uint(0); j < path.Limit; j++ {
 				paths[i] = append(paths[i], matches...)
 			}
 			// Keep track of the shortest reusable length in the group.
 			if len(paths[i]) < limitLength {
 				limitLength = len(paths[i])
+				limitPath = i
 			}
 			// Keep track of the greatest natural length in the group.
 			if len(matches) > length {
 				length = len(matches)
 			}
 		}
+		if slices.ContainsFunc(keyed, func(m map[string]match) bool { return m != nil }) {
+			joined, cappedBy := joinKeys(group, keyed, values)
+			for i, m := range keyed {
+				if m == nil || !pathHasMatches[i] {
+					continue
+				}
+				// Optional paths without a match for a key are left out of the device for that key.
+				for _, v := range joined {
+					paths[i] = append(paths[i], m[strings.Join(v, "/")])
+				}
+				// Keys that are missing from other paths cap the length like missing matches.
+				if len(m) > length {
+					length = len(m)
+				}
+			}
+			// The joined paths behave like a single path whose matches can be used once.
+			if len(joined) < limitLength {
+				limitLength = len(joined)
+				limitPath = cappedBy
+			}
+		}
 		// Cap the length at the maximum reusable length.
 		if length > limitLength {
 			length = limitLength
+			explanation.cappedBy = limitPath
 		}
+		// groupNodes holds the host nodes of the devices of this group.
+		groupNodes := make(map[string]struct{})
 		for i := 0; i < length; i++ {
+			var targets []string
+			for k := range groupPaths {
+				if pathHasMatches[k] && paths[k][i].path != "" {
+					targets = append(targets, paths[k][i].target)
+				}
+			}
+			slices.Sort(targets)
+			key := strings.Join(targets, "\x00")
+			if _, ok := produced[key]; ok {
+				_ = level.Debug(gp.logger).Log("msg", "skipping device with the same host nodes as a device of another group", "group", gi, "nodes", strings.Join(targets, ","))
+				continue
+			}
+			groupNodes[key] = struct{}{}
+			// Look up the identities of the host devices once for all copies of the group.
+			// Identities use the matched paths, since links are often more stable than their targets.
+			var nodes []identity
+			for k := range groupPaths {
+				if !pathHasMatches[k] || paths[k][i].path == "" {
+					continue
+				}
+				if group.needsIdentity() {
+					id := identify(gp.fs, paths[k][i].target)
+					id.Path = paths[k][i].path
+					nodes = append(nodes, id)
+				} else {
+					nodes = append(nodes, identity{Path: paths[k][i].path})
+				}
+			}
 			for j := uint(0); j < group.Count; j++ {
-				h := sha1.New()
-				h.Write([]byte(strconv.FormatUint(uint64(j), 10)))
 				d := device{
 					Device: &v1beta1.Device{
 						Health: v1beta1.Healthy,
 					},
 				}
-				for k, path := range group.Paths {
+				for k, path := range groupPaths {
 					// Skip paths that had no matches (optional and missing).
-					if !pathHasMatches[k] {
+					if !pathHasMatches[k] || paths[k][i].path == "" {
 						continue
 					}
-					mountPath = path.MountPath
-					if mountPath == "" {
-						mountPath = paths[k][i]
+					m := paths[k][i]
+					mountPath, err := path.containerPath(m, i, j)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+						continue Groups
 					}
-					if strings.HasSuffix(mountPath, "/") {
-						mountPath = mountPath + filepath.Base(paths[k][i])
+					containerPaths := []string{mountPath}
+					if path.KeepLinks && m.path != m.target && m.path != mountPath {
+						containerPaths = append(containerPaths, m.path)
 					}
-					switch path.Type {
-					case DevicePathType:
-						d.deviceSpecs = append(d.deviceSpecs, &v1beta1.DeviceSpec{
-							HostPath:      paths[k][i],
-							ContainerPath: mountPath,
-							Permissions:   path.Permissions,
-						})
-					case MountPathType:
-						d.mounts = append(d.mounts, &v1beta1.Mount{
-							HostPath:      paths[k][i],
-							ContainerPath: mountPath,
-							ReadOnly:      path.ReadOnly,
-						})
+					for _, cp := range containerPaths {
+						if path.Type.isDevice() {
+							d.deviceSpecs = append(d.deviceSpecs, &v1beta1.DeviceSpec{
+								HostPath:      m.target,
+								ContainerPath: cp,
+								Permissions:   path.Permissions,
+							})
+						} else {
+							d.mounts = append(d.mounts, &v1beta1.Mount{
+								HostPath:      m.target,
+								ContainerPath: cp,
+								ReadOnly:      path.ReadOnly,
+							})
+						}
 					}
-					h.Write([]byte(paths[k][i]))
 				}
-				d.ID = fmt.Sprintf("%x", h.Sum(nil))
-				devices = append(devices, d)
+				id, err := group.deviceID(j, i, nodes)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+					continue Groups
+				}
+				d.ID = id
+				groupDevices = append(groupDevices, d)
 			}
 		}
+		explanation.length = length
+		explanation.devices = len(groupDevices)
+		gp.explain(gi, explanation)
+		devices = append(devices, groupDevices...)
+		maps.Copy(produced, groupNodes)
 	}
-	return devices, nil
+	gp.report(wrongType)
+	return devices, errors.Join(errs...)
 }
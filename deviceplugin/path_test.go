@@ -15,12 +15,18 @@
 package deviceplugin
 
 import (
+	"context"
+	"errors"
 	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
 	"testing/fstest"
 
-	"github.com/squat/generic-device-plugin/absolute"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/squat/generic-device-plugin/absolute"
 )
 
 func TestDiscoverPaths(t *testing.T) {
@@ -50,7 +56,7 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/simple": {},
+				"dev/simple": charDevice,
 			},
 			out: []device{
 				{
@@ -80,10 +86,10 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/ttyUSB0": {},
-				"dev/ttyUSB1": {},
-				"dev/ttyUSB2": {},
-				"dev/ttyUSB3": {},
+				"dev/ttyUSB0": charDevice,
+				"dev/ttyUSB1": charDevice,
+				"dev/ttyUSB2": charDevice,
+				"dev/ttyUSB3": charDevice,
 			},
 			out: []device{
 				{
@@ -137,10 +143,10 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/ttyUSB0": {},
-				"dev/ttyUSB1": {},
-				"dev/ttyUSB2": {},
-				"dev/ttyUSB3": {},
+				"dev/ttyUSB0": charDevice,
+				"dev/ttyUSB1": charDevice,
+				"dev/ttyUSB2": charDevice,
+				"dev/ttyUSB3": charDevice,
 			},
 			out: []device{
 				{
@@ -196,7 +202,7 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/does/exist": {},
+				"dev/does/exist": charDevice,
 			},
 			err: nil,
 		},
@@ -224,7 +230,7 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/ttyUSB0": {},
+				"dev/ttyUSB0": charDevice,
 			},
 			out: []device{
 				{
@@ -262,9 +268,9 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/ttyS0":   {},
-				"dev/ttyUSB0": {},
-				"dev/ttyUSB1": {},
+				"dev/ttyS0":   charDevice,
+				"dev/ttyUSB0": charDevice,
+				"dev/ttyUSB1": charDevice,
 			},
 			out: []device{
 				{
@@ -325,9 +331,9 @@ func TestDiscoverPaths(t *testing.T) {
 				},
 			},
 			fs: fstest.MapFS{
-				"dev/input/event0": {},
-				"dev/input/event1": {},
-				"dev/input/event2": {},
+				"dev/input/event0": charDevice,
+				"dev/input/event1": charDevice,
+				"dev/input/event2": charDevice,
 			},
 			out: []device{
 				{
@@ -341,43 +347,1228 @@ func TestDiscoverPaths(t *testing.T) {
 			},
 			err: nil,
 		},
-	} {
-		t.Run(tc.name, func(t *testing.T) {
-			tc.ds.Default()
-			p := GenericPlugin{
-				ds: tc.ds,
-				fs: absolute.New(tc.fs, "/"),
-			}
-
-			out, err := p.discoverPath()
-			if (err != nil) != (tc.err != nil) {
-				t.Errorf("expected error %v; got %v", tc.err, err)
-			}
-			if len(out) != len(tc.out) {
-				t.Errorf("expected %d devices; got %d", len(tc.out), len(out))
-				return
-			}
-			for i := range out {
-				if len(out[i].deviceSpecs) != len(tc.out[i].deviceSpecs) {
-					t.Errorf("device %d: expected %d deviceSpecs; got %d", i, len(tc.out[i].deviceSpecs), len(out[i].deviceSpecs))
-					break
-				}
-				for j := range out[i].deviceSpecs {
-					if out[i].deviceSpecs[j].ContainerPath != tc.out[i].deviceSpecs[j].ContainerPath {
-						t.Errorf("device %d, device spec %d: expected container path %q; got %q", i, j, tc.out[i].deviceSpecs[j].ContainerPath, out[i].deviceSpecs[j].ContainerPath)
-					}
-					if out[i].deviceSpecs[j].HostPath != tc.out[i].deviceSpecs[j].HostPath {
-						t.Errorf("device %d, device spec %d: expected host path %q; got %q", i, j, tc.out[i].deviceSpecs[j].HostPath, out[i].deviceSpecs[j].HostPath)
-					}
-				}
-				for j := range out[i].mounts {
-					if out[i].mounts[j].ContainerPath != tc.out[i].mounts[j].ContainerPath {
-						t.Errorf("device %d, mount %d: expected container path %q; got %q", i, j, tc.out[i].mounts[j].ContainerPath, out[i].mounts[j].ContainerPath)
-					}
-					if out[i].mounts[j].HostPath != tc.out[i].mounts[j].HostPath {
-						t.Errorf("device %d, mount %d: expected host path %q; got %q", i, j, tc.out[i].mounts[j].HostPath, out[i].mounts[j].HostPath)
-					}
-				}
+		{
+			name: "symlinks resolve to their targets",
+			ds: &DeviceSpec{
+				Name: "serial",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/serial/by-id/usb-FTDI_*",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/ttyUSB0":                   charDevice,
+				"dev/ttyUSB1":                   charDevice,
+				"dev/serial/by-id/usb-FTDI_A-0": {Data: []byte("../../ttyUSB1"), Mode: fs.ModeSymlink},
+				"dev/serial/by-id/usb-FTDI_B-0": {Data: []byte("/dev/ttyUSB0"), Mode: fs.ModeSymlink},
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/ttyUSB1",
+							HostPath:      "/dev/ttyUSB1",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/ttyUSB0",
+							HostPath:      "/dev/ttyUSB0",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "symlinks are deduplicated by target",
+			ds: &DeviceSpec{
+				Name: "serial",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/serial/by-id/*",
+							},
+						},
+					},
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/serial/by-path/*",
+							},
+						},
+					},
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/ttyUSB*",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/ttyUSB0":                          charDevice,
+				"dev/ttyUSB1":                          charDevice,
+				"dev/serial/by-id/usb-FTDI_A-0":        {Data: []byte("../../ttyUSB0"), Mode: fs.ModeSymlink},
+				"dev/serial/by-path/pci-usb-0:1:1.0-0": {Data: []byte("../../ttyUSB0"), Mode: fs.ModeSymlink},
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/ttyUSB0",
+							HostPath:      "/dev/ttyUSB0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/ttyUSB1",
+							HostPath:      "/dev/ttyUSB1",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "keep links",
+			ds: &DeviceSpec{
+				Name: "serial",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:      "/dev/serial/by-id/*",
+								KeepLinks: true,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/ttyUSB0":                   charDevice,
+				"dev/serial/by-id/usb-FTDI_A-0": {Data: []byte("../../ttyUSB0"), Mode: fs.ModeSymlink},
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/ttyUSB0",
+							HostPath:      "/dev/ttyUSB0",
+						},
+						{
+							ContainerPath: "/dev/serial/by-id/usb-FTDI_A-0",
+							HostPath:      "/dev/ttyUSB0",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "correlation keys",
+			ds: &DeviceSpec{
+				Name: "capture",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/snd/controlC{card}",
+							},
+							{
+								Path: "/dev/snd/pcmC{card}D0c",
+							},
+							{
+								Path:     "/dev/snd/hwC{card}D0",
+								Optional: true,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd/controlC0":  charDevice,
+				"dev/snd/controlC1":  charDevice,
+				"dev/snd/controlC2":  charDevice,
+				"dev/snd/controlC10": charDevice,
+				"dev/snd/pcmC0D0c":   charDevice,
+				"dev/snd/pcmC2D0c":   charDevice,
+				"dev/snd/pcmC10D0c":  charDevice,
+				"dev/snd/hwC2D0":     charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/controlC0",
+							HostPath:      "/dev/snd/controlC0",
+						},
+						{
+							ContainerPath: "/dev/snd/pcmC0D0c",
+							HostPath:      "/dev/snd/pcmC0D0c",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/controlC2",
+							HostPath:      "/dev/snd/controlC2",
+						},
+						{
+							ContainerPath: "/dev/snd/pcmC2D0c",
+							HostPath:      "/dev/snd/pcmC2D0c",
+						},
+						{
+							ContainerPath: "/dev/snd/hwC2D0",
+							HostPath:      "/dev/snd/hwC2D0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/controlC10",
+							HostPath:      "/dev/snd/controlC10",
+						},
+						{
+							ContainerPath: "/dev/snd/pcmC10D0c",
+							HostPath:      "/dev/snd/pcmC10D0c",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "correlation keys with shared path",
+			ds: &DeviceSpec{
+				Name: "midi",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/snd/midiC{card}D{device}",
+							},
+							{
+								Path:  "/dev/snd/seq",
+								Limit: 10,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd/midiC0D0": charDevice,
+				"dev/snd/midiC0D1": charDevice,
+				"dev/snd/midiC1D0": charDevice,
+				"dev/snd/seq":      charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/midiC0D0",
+							HostPath:      "/dev/snd/midiC0D0",
+						},
+						{
+							ContainerPath: "/dev/snd/seq",
+							HostPath:      "/dev/snd/seq",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/midiC0D1",
+							HostPath:      "/dev/snd/midiC0D1",
+						},
+						{
+							ContainerPath: "/dev/snd/seq",
+							HostPath:      "/dev/snd/seq",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/midiC1D0",
+							HostPath:      "/dev/snd/midiC1D0",
+						},
+						{
+							ContainerPath: "/dev/snd/seq",
+							HostPath:      "/dev/snd/seq",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "regex with exclude",
+			ds: &DeviceSpec{
+				Name: "video",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:    "/dev/video[0-9]+",
+								Match:   RegexMatchMode,
+								Exclude: []string{"/dev/video[0-9]*[13579]"},
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/video0":  charDevice,
+				"dev/video1":  charDevice,
+				"dev/video2":  charDevice,
+				"dev/video3":  charDevice,
+				"dev/videoX":  charDevice,
+				"dev/video10": charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video0",
+							HostPath:      "/dev/video0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video10",
+							HostPath:      "/dev/video10",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video2",
+							HostPath:      "/dev/video2",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "doublestar",
+			ds: &DeviceSpec{
+				Name: "gpu",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:    "/dev/dri/by-path/**",
+								Match:   DoublestarMatchMode,
+								Exclude: []string{"/dev/dri/by-path/*-render"},
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/dri/card0":                           charDevice,
+				"dev/dri/card1":                           charDevice,
+				"dev/dri/renderD128":                      charDevice,
+				"dev/dri/by-path/pci-0000:00:02.0-card":   {Mode: fs.ModeSymlink, Data: []byte("../card0")},
+				"dev/dri/by-path/pci-0000:00:02.0-render": {Mode: fs.ModeSymlink, Data: []byte("../renderD128")},
+				"dev/dri/by-path/pci-0000:01:00.0-card":   {Mode: fs.ModeSymlink, Data: []byte("../card1")},
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/dri/card0",
+							HostPath:      "/dev/dri/card0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/dri/card1",
+							HostPath:      "/dev/dri/card1",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "templated mount path with captures",
+			ds: &DeviceSpec{
+				Name: "serial",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:      `/dev/ttyUSB(\d+)`,
+								Match:     RegexMatchMode,
+								MountPath: "/dev/serial/port{{index .Captures 1}}",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/ttyUSB0": charDevice,
+				"dev/ttyUSB7": charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/serial/port0",
+							HostPath:      "/dev/ttyUSB0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/serial/port7",
+							HostPath:      "/dev/ttyUSB7",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "templated mount path with index and slot",
+			ds: &DeviceSpec{
+				Name: "video",
+				Groups: []*Group{
+					{
+						Count: 2,
+						Paths: []*Path{
+							{
+								Path:      "/dev/video*",
+								MountPath: "/dev/video{{.Index}}-{{.Slot}}",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/video2": charDevice,
+				"dev/video4": charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video0-0",
+							HostPath:      "/dev/video2",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video0-1",
+							HostPath:      "/dev/video2",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video1-0",
+							HostPath:      "/dev/video4",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video1-1",
+							HostPath:      "/dev/video4",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "templated mount path for mounts",
+			ds: &DeviceSpec{
+				Name: "sound",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:      "/dev/snd/controlC{card}",
+								Type:      MountPathType,
+								MountPath: "/snd/{{.Keys.card}}/{{.Base}}",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd/controlC3": charDevice,
+			},
+			out: []device{
+				{
+					mounts: []*v1beta1.Mount{
+						{
+							ContainerPath: "/snd/3/controlC3",
+							HostPath:      "/dev/snd/controlC3",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "templated mount path with missing key",
+			ds: &DeviceSpec{
+				Name: "sound",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:      "/dev/snd/controlC*",
+								MountPath: "/snd/{{.Keys.card}}",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd/controlC3": charDevice,
+			},
+			err: errors.New("failed to execute mount path template"),
+		},
+		{
+			name: "device type skips other nodes",
+			ds: &DeviceSpec{
+				Name: "disk",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/sd*",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/sda":      blockDevice,
+				"dev/sdb":      {},
+				"dev/sdc":      {Mode: fs.ModeNamedPipe},
+				"dev/sdd.sock": {Mode: fs.ModeSocket},
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/sda",
+							HostPath:      "/dev/sda",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "device directory",
+			ds: &DeviceSpec{
+				Name: "audio",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/snd",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd":           {Mode: fs.ModeDir},
+				"dev/snd/controlC0": charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd",
+							HostPath:      "/dev/snd",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "char device type skips directories",
+			ds: &DeviceSpec{
+				Name: "audio",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/snd",
+								Type: CharDevicePathType,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd/controlC0": charDevice,
+			},
+			out: nil,
+			err: nil,
+		},
+		{
+			name: "char and block devices",
+			ds: &DeviceSpec{
+				Name: "devices",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/*",
+								Type: CharDevicePathType,
+							},
+						},
+					},
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/*",
+								Type: BlockDevicePathType,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/sda":   blockDevice,
+				"dev/ttyS0": charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/ttyS0",
+							HostPath:      "/dev/ttyS0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/sda",
+							HostPath:      "/dev/sda",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "file, directory, and socket",
+			ds: &DeviceSpec{
+				Name: "app",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/etc/app/*",
+								Type: FilePathType,
+							},
+							{
+								Path: "/var/lib/app/*",
+								Type: DirectoryPathType,
+							},
+							{
+								Path: "/run/app/*",
+								Type: SocketPathType,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"etc/app/config":      {},
+				"etc/app/dir":         {Mode: fs.ModeDir},
+				"var/lib/app/data":    {Mode: fs.ModeDir},
+				"var/lib/app/file":    {},
+				"run/app/api.sock":    {Mode: fs.ModeSocket},
+				"run/app/api.pid":     {},
+				"run/app/ttyUSB0.dev": charDevice,
+			},
+			out: []device{
+				{
+					mounts: []*v1beta1.Mount{
+						{
+							ContainerPath: "/etc/app/config",
+							HostPath:      "/etc/app/config",
+						},
+						{
+							ContainerPath: "/var/lib/app/data",
+							HostPath:      "/var/lib/app/data",
+						},
+						{
+							ContainerPath: "/run/app/api.sock",
+							HostPath:      "/run/app/api.sock",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "directory or create",
+			ds: &DeviceSpec{
+				Name: "cache",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path:      "/var/cache/app",
+								MountPath: "/cache",
+								Type:      DirectoryOrCreatePathType,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{},
+			out: []device{
+				{
+					mounts: []*v1beta1.Mount{
+						{
+							ContainerPath: "/cache",
+							HostPath:      "/var/cache/app",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "node shared across groups",
+			ds: &DeviceSpec{
+				Name: "sound",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/snd/controlC0",
+							},
+							{
+								Path: "/dev/snd/pcmC0D0c",
+							},
+						},
+					},
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/snd/controlC0",
+							},
+							{
+								Path: "/dev/snd/pcmC0D0p",
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"dev/snd/controlC0": charDevice,
+				"dev/snd/pcmC0D0c":  charDevice,
+				"dev/snd/pcmC0D0p":  charDevice,
+			},
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/controlC0",
+							HostPath:      "/dev/snd/controlC0",
+						},
+						{
+							ContainerPath: "/dev/snd/pcmC0D0c",
+							HostPath:      "/dev/snd/pcmC0D0c",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/snd/controlC0",
+							HostPath:      "/dev/snd/controlC0",
+						},
+						{
+							ContainerPath: "/dev/snd/pcmC0D0p",
+							HostPath:      "/dev/snd/pcmC0D0p",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "device selector",
+			ds: &DeviceSpec{
+				Name: "serial",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Device:    &DeviceSelector{Drivers: []string{"cdc_acm"}},
+								MountPath: "/dev/serial/",
+							},
+						},
+					},
+				},
+			},
+			fs: sysDevFS(),
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/serial/ttyACM0",
+							HostPath:      "/dev/ttyACM0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/serial/ttyACM1",
+							HostPath:      "/dev/ttyACM1",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "sysfs",
+			ds: &DeviceSpec{
+				Name: "camera",
+				Groups: []*Group{
+					{
+						Sysfs: []*SysfsSpec{
+							{
+								Class:      "video4linux",
+								Attributes: []*AttributePredicate{{Name: "index", Glob: "0"}},
+								MountPath:  "/dev/video0",
+							},
+							{
+								Class:    "hidraw",
+								Optional: true,
+							},
+						},
+					},
+				},
+			},
+			fs: sysfsFS(),
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video0",
+							HostPath:      "/dev/video0",
+						},
+					},
+				},
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video0",
+							HostPath:      "/dev/video2",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "sysfs with paths",
+			ds: &DeviceSpec{
+				Name: "sensor",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/dev/video1",
+							},
+						},
+						Sysfs: []*SysfsSpec{
+							{
+								Class:      "iio",
+								Attributes: []*AttributePredicate{{Name: "name", Regex: "bme[0-9]+"}},
+							},
+						},
+					},
+				},
+			},
+			fs: sysfsFS(),
+			out: []device{
+				{
+					deviceSpecs: []*v1beta1.DeviceSpec{
+						{
+							ContainerPath: "/dev/video1",
+							HostPath:      "/dev/video1",
+						},
+						{
+							ContainerPath: "/dev/iio:device0",
+							HostPath:      "/dev/iio:device0",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		{
+			name: "directory or create with a file in the way",
+			ds: &DeviceSpec{
+				Name: "cache",
+				Groups: []*Group{
+					{
+						Paths: []*Path{
+							{
+								Path: "/var/cache/app",
+								Type: DirectoryOrCreatePathType,
+							},
+						},
+					},
+				},
+			},
+			fs: fstest.MapFS{
+				"var/cache/app": {},
+			},
+			out: nil,
+			err: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestGenericPlugin(t, tc.ds, tc.fs)
+
+			out, err := p.discoverPath(context.Background())
+			if (err != nil) != (tc.err != nil) {
+				t.Errorf("expected error %v; got %v", tc.err, err)
+			}
+			if len(out) != len(tc.out) {
+				t.Errorf("expected %d devices; got %d", len(tc.out), len(out))
+				return
+			}
+			for i := range out {
+				if len(out[i].deviceSpecs) != len(tc.out[i].deviceSpecs) {
+					t.Errorf("device %d: expected %d deviceSpecs; got %d", i, len(tc.out[i].deviceSpecs), len(out[i].deviceSpecs))
+					break
+				}
+				for j := range out[i].deviceSpecs {
+					if out[i].deviceSpecs[j].ContainerPath != tc.out[i].deviceSpecs[j].ContainerPath {
+						t.Errorf("device %d, device spec %d: expected container path %q; got %q", i, j, tc.out[i].deviceSpecs[j].ContainerPath, out[i].deviceSpecs[j].ContainerPath)
+					}
+					if out[i].deviceSpecs[j].HostPath != tc.out[i].deviceSpecs[j].HostPath {
+						t.Errorf("device %d, device spec %d: expected host path %q; got %q", i, j, tc.out[i].deviceSpecs[j].HostPath, out[i].deviceSpecs[j].HostPath)
+					}
+				}
+				if len(out[i].mounts) != len(tc.out[i].mounts) {
+					t.Errorf("device %d: expected %d mounts; got %d", i, len(tc.out[i].mounts), len(out[i].mounts))
+					break
+				}
+				for j := range out[i].mounts {
+					if out[i].mounts[j].ContainerPath != tc.out[i].mounts[j].ContainerPath {
+						t.Errorf("device %d, mount %d: expected container path %q; got %q", i, j, tc.out[i].mounts[j].ContainerPath, out[i].mounts[j].ContainerPath)
+					}
+					if out[i].mounts[j].HostPath != tc.out[i].mounts[j].HostPath {
+						t.Errorf("device %d, mount %d: expected host path %q; got %q", i, j, tc.out[i].mounts[j].HostPath, out[i].mounts[j].HostPath)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoverPathsInHostRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dev/serial/by-id"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dev/ttyUSB0"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Absolute links point into the host's root, not the root of the process.
+	if err := os.Symlink("/dev/ttyUSB0", filepath.Join(root, "dev/serial/by-id/usb-FTDI_A-0")); err != nil {
+		t.Fatal(err)
+	}
+	ds := &DeviceSpec{
+		Name: "serial",
+		Groups: []*Group{
+			{
+				Paths: []*Path{
+					{
+						// Device nodes cannot be created without privileges.
+						Path: "/dev/serial/by-id/*",
+						Type: FilePathType,
+					},
+					{
+						Path: "/run/serial",
+						Type: DirectoryOrCreatePathType,
+					},
+				},
+			},
+		},
+	}
+	ds.Default()
+	p := NewGenericPlugin(ds, t.TempDir(), nil, nil, false, nil, root).(*plugin).DevicePluginServer.(*GenericPlugin)
+	out, err := p.discoverPath(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) != 1 || len(out[0].mounts) != 2 {
+		t.Fatalf("expected 1 device with 2 mounts; got %d devices", len(out))
+	}
+	if hp := out[0].mounts[0].HostPath; hp != "/dev/ttyUSB0" {
+		t.Errorf("expected unprefixed host path %q; got %q", "/dev/ttyUSB0", hp)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "run/serial")); err != nil || !fi.IsDir() {
+		t.Errorf("expected directory to be created in the host root; got %v", err)
+	}
+}
+
+func TestExplainGroups(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dev/snd/controlC0": charDevice,
+		"dev/snd/controlC1": charDevice,
+		"dev/snd/controlC2": charDevice,
+		"dev/snd/controlC3": charDevice,
+		"dev/snd/pcmC0D0c":  charDevice,
+		"dev/snd/pcmC1D0c":  charDevice,
+		"dev/snd/seq":       charDevice,
+		"dev/snd/hwC0D0":    charDevice,
+		"dev/snd/hwC1D0":    charDevice,
+		"dev/snd/hwC2D0":    charDevice,
+		"dev/snd/hwC3D0":    charDevice,
+		"dev/snd/timer":     charDevice,
+		"dev/snd/midiC0D0":  charDevice,
+	}
+	ds := &DeviceSpec{
+		Name: "capture",
+		Groups: []*Group{
+			{Paths: []*Path{{Path: "/dev/snd/controlC?"}, {Path: "/dev/snd/pcmC?D0c"}, {Path: "/dev/snd/compr*", Optional: true}}},
+			{Paths: []*Path{{Path: "/dev/snd/hwC?D0"}, {Path: "/dev/snd/seq", Limit: 10}}},
+			{Paths: []*Path{{Path: "/dev/snd/timer", Limit: 2}, {Path: "/dev/snd/midiC?D0", Limit: 3}}},
+		},
+	}
+	ds.Default()
+	reg := prometheus.NewRegistry()
+	gp := NewGenericPlugin(ds, t.TempDir(), nil, reg, false, nil, "/").(*plugin).DevicePluginServer.(*GenericPlugin)
+	gp.fs = absolute.New(fsys, "/")
+	if _, err := gp.discoverPath(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for gi, expected := range []groupExplanation{
+		{
+			paths: []pathExplanation{
+				{path: "/dev/snd/controlC?", matches: 4, limit: 1},
+				{path: "/dev/snd/pcmC?D0c", matches: 2, limit: 1},
+				{path: "/dev/snd/compr*", limit: 1, optional: true},
+			},
+			length:   2,
+			cappedBy: 1,
+			devices:  2,
+		},
+		{
+			paths: []pathExplanation{
+				{path: "/dev/snd/hwC?D0", matches: 4, limit: 1},
+				{path: "/dev/snd/seq", matches: 1, limit: 10},
+			},
+			length:   4,
+			cappedBy: -1,
+			devices:  4,
+		},
+		{
+			paths: []pathExplanation{
+				{path: "/dev/snd/timer", matches: 1, limit: 2},
+				{path: "/dev/snd/midiC?D0", matches: 1, limit: 3},
+			},
+			length:   1,
+			cappedBy: -1,
+			devices:  1,
+		},
+	} {
+		if e := gp.explanations[gi]; !e.equal(expected) {
+			t.Errorf("group %d: expected %+v; got %+v", gi, expected, e)
+		}
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	capped := make(map[string]string)
+	devices := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			switch mf.GetName() {
+			case "generic_device_plugin_group_capped_by":
+				capped[labels["group"]] = labels["path"]
+			case "generic_device_plugin_group_devices":
+				devices[labels["group"]] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	if len(capped) != 1 || capped["0"] != "/dev/snd/pcmC?D0c" {
+		t.Errorf("expected group 0 to be capped by %q; got %v", "/dev/snd/pcmC?D0c", capped)
+	}
+	if devices["0"] != 2 || devices["1"] != 4 || devices["2"] != 1 {
+		t.Errorf("expected 2, 4, and 1 devices; got %v", devices)
+	}
+}
+
+func TestExplainCorrelatedGroups(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dev/snd/controlC0": charDevice,
+		"dev/snd/controlC1": charDevice,
+		"dev/snd/controlC2": charDevice,
+		"dev/snd/pcmC0D0c":  charDevice,
+		"dev/snd/pcmC2D0c":  charDevice,
+	}
+	gp := newTestGenericPlugin(t, &DeviceSpec{
+		Name:   "capture",
+		Groups: []*Group{{Paths: []*Path{{Path: "/dev/snd/controlC{card}"}, {Path: "/dev/snd/pcmC{card}D0c"}}}},
+	}, fsys)
+	if _, err := gp.discoverPath(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := groupExplanation{
+		paths: []pathExplanation{
+			{path: "/dev/snd/controlC{card}", matches: 3, limit: 1},
+			{path: "/dev/snd/pcmC{card}D0c", matches: 2, limit: 1},
+		},
+		length:   2,
+		cappedBy: 1,
+		devices:  2,
+	}
+	if e := gp.explanations[0]; !e.equal(expected) {
+		t.Errorf("expected %+v; got %+v", expected, e)
+	}
+}
+
+func TestWrongTypeSkipped(t *testing.T) {
+	e := NewDiscoveryEngine("/", nil, nil)
+	e.fs = absolute.New(fstest.MapFS{
+		"dev/ttyUSB0": charDevice,
+		"dev/ttyUSB1": {},
+	}, "/")
+	ds := &DeviceSpec{Name: "serial", Groups: []*Group{{Paths: []*Path{{Path: "/dev/ttyUSB*"}}}}}
+	ds.Default()
+	gp := NewGenericPlugin(ds, t.TempDir(), nil, nil, false, nil, "/", WithDiscoveryEngine(e)).(*plugin).DevicePluginServer.(*GenericPlugin)
+	out, err := gp.discoverPath(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) != 1 || out[0].deviceSpecs[0].HostPath != "/dev/ttyUSB0" {
+		t.Fatalf("expected only /dev/ttyUSB0; got %d devices", len(out))
+	}
+	skipped := e.Skipped()
+	if len(skipped) != 1 || skipped[0].Path != "/dev/ttyUSB1" || skipped[0].Source != pathSource || skipped[0].Reason != WrongTypeSkipReason {
+		t.Errorf("expected /dev/ttyUSB1 to be skipped for its type; got %+v", skipped)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path *Path
+		err  bool
+	}{
+		{
+			name: "default type",
+			path: &Path{Path: "/dev/ttyUSB*"},
+		},
+		{
+			name: "known type",
+			path: &Path{Path: "/run/app/*.sock", Type: SocketPathType},
+		},
+		{
+			name: "unknown type",
+			path: &Path{Path: "/dev/ttyUSB*", Type: "Pipe"},
+			err:  true,
+		},
+		{
+			name: "directory or create",
+			path: &Path{Path: "/var/cache/app", Type: DirectoryOrCreatePathType},
+		},
+		{
+			name: "device selector",
+			path: &Path{Device: &DeviceSelector{Drivers: []string{"cdc_acm"}}, Type: CharDevicePathType},
+		},
+		{
+			name: "device selector and path",
+			path: &Path{Path: "/dev/ttyACM*", Device: &DeviceSelector{Drivers: []string{"cdc_acm"}}},
+			err:  true,
+		},
+		{
+			name: "device selector with mount type",
+			path: &Path{Device: &DeviceSelector{Drivers: []string{"cdc_acm"}}, Type: MountPathType},
+			err:  true,
+		},
+		{
+			name: "directory or create with wildcards",
+			path: &Path{Path: "/var/cache/app*", Type: DirectoryOrCreatePathType},
+			err:  true,
+		},
+		{
+			name: "directory or create with keys",
+			path: &Path{Path: "/var/cache/{app}", Type: DirectoryOrCreatePathType},
+			err:  true,
+		},
+		{
+			name: "directory or create with regex",
+			path: &Path{Path: "/var/cache/app", Match: RegexMatchMode, Type: DirectoryOrCreatePathType},
+			err:  true,
+		},
+		{
+			name: "regex below a literal directory",
+			path: &Path{Path: "/dev/.*video.*[0-9]", Match: RegexMatchMode},
+		},
+		{
+			name: "regex without a literal directory",
+			path: &Path{Path: "/video[0-9]+", Match: RegexMatchMode},
+			err:  true,
+		},
+		{
+			name: "regex with alternative directories",
+			path: &Path{Path: "(/dev|/run)/video[0-9]+", Match: RegexMatchMode},
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.path.validate(); (err != nil) != tc.err {
+				t.Errorf("expected error %t; got %v", tc.err, err)
 			}
 		})
 	}
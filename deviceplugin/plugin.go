@@ -17,10 +17,15 @@ package deviceplugin
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
@@ -28,20 +33,130 @@ import (
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	pluginregistrationv1 "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
 )
 
 const (
 	socketPrefix        = "gdp"
+	handoffSuffix       = ".handoff"
 	socketCheckInterval = 1 * time.Second
-	restartInterval     = 5 * time.Second
+	socketDialTimeout   = 1 * time.Second
+	// DefaultPluginsRegistryDir is the directory watched by the kubelet's plugin watcher.
+	DefaultPluginsRegistryDir = "/var/lib/kubelet/plugins_registry/"
 )
 
+// RegistrationMode describes how a plugin makes itself known to the kubelet.
+type RegistrationMode string
+
+const (
+	// RegisterRegistrationMode registers the plugin by calling the kubelet's Registration service
+	// on the kubelet socket in the plugin directory.
+	RegisterRegistrationMode RegistrationMode = "register"
+	// PluginWatcherRegistrationMode places the plugin socket in the kubelet's plugins registry directory,
+	// where the kubelet's plugin watcher discovers it on its own, including after kubelet restarts.
+	PluginWatcherRegistrationMode RegistrationMode = "plugin-watcher"
+)
+
+// RegistrationModes is the list of all supported registration modes.
+var RegistrationModes = []RegistrationMode{RegisterRegistrationMode, PluginWatcherRegistrationMode}
+
+// KnownPluginDirs are the device plugin directories used by common Kubernetes distributions.
+var KnownPluginDirs = []string{
+	v1beta1.DevicePluginPath,
+	"/var/lib/k0s/kubelet/device-plugins/",
+	"/var/snap/microk8s/common/var/lib/kubelet/device-plugins/",
+	"/var/lib/rancher/k3s/agent/kubelet/device-plugins/",
+}
+
+// DetectPluginDir returns the first of the given device plugin directories
+// that contains a kubelet socket on which the kubelet is listening.
+func DetectPluginDir(dirs []string) (string, error) {
+	for _, dir := range dirs {
+		if socketAlive(filepath.Join(dir, filepath.Base(v1beta1.KubeletSocket))) {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find a running kubelet in any of %s", strings.Join(dirs, ", "))
+}
+
+// options holds the optional configuration of a plugin.
+type options struct {
+	registrationMode  RegistrationMode
+	registryDir       string
+	kubeletSocketPath string
+	drainGracePeriod  time.Duration
+	engine            *DiscoveryEngine
+}
+
+// Option configures optional behavior of a plugin.
+type Option func(*options)
+
+// WithKubeletSocket makes the plugin register with the kubelet at the given socket
+// instead of the kubelet socket in the plugin directory.
+func WithKubeletSocket(path string) Option {
+	return func(o *options) {
+		o.kubeletSocketPath = path
+	}
+}
+
+// WithDrainGracePeriod makes the plugin mark all devices unhealthy when its context
+// is cancelled and wait for the given duration before it stops serving,
+// so that the kubelet stops scheduling Pods onto the devices in the meantime.
+func WithDrainGracePeriod(d time.Duration) Option {
+	return func(o *options) {
+		o.drainGracePeriod = d
+	}
+}
+
+// WithDiscoveryEngine makes generic plugins discover their devices through the given
+// DiscoveryEngine, which is shared with all other plugins using it.
+// The engine, and not the plugins, then decides when devices are refreshed.
+func WithDiscoveryEngine(e *DiscoveryEngine) Option {
+	return func(o *options) {
+		o.engine = e
+	}
+}
+
+// WithPluginWatcher makes the plugin register through the kubelet's plugin watcher
+// by creating its socket in the given plugins registry directory.
+func WithPluginWatcher(registryDir string) Option {
+	return func(o *options) {
+		o.registrationMode = PluginWatcherRegistrationMode
+		o.registryDir = registryDir
+	}
+}
+
 // Plugin is a Kubernetes device plugin that can be run.
 type Plugin interface {
 	v1beta1.DevicePluginServer
 	Run(context.Context) error
+	// State returns the current stage in the lifecycle of the plugin.
+	State() State
+	// FailingSince returns when the plugin started failing to register with the kubelet
+	// without being registered since, or the zero time if it is not failing.
+	FailingSince() time.Time
+}
+
+// looper is implemented by device plugin servers that
+// need to work in the background while the plugin runs.
+type looper interface {
+	loop(context.Context)
+}
+
+// drainer is implemented by device plugin servers that can
+// tell the kubelet that all of their devices are going away.
+type drainer interface {
+	drain()
+}
+
+// advertiser is implemented by device plugin servers that can
+// tell when they first advertised devices to the kubelet.
+type advertiser interface {
+	advertised() <-chan struct{}
 }
 
 // plugin is a Kubernetes device plugin.
@@ -54,57 +169,224 @@ type plugin struct {
 	socket     string
 	grpcServer *grpc.Server
 	logger     log.Logger
+	options
+
+	mu    sync.Mutex
+	state State
+	// failingSince is when the plugin entered a failure state after it was last registered.
+	failingSince time.Time
 
 	// metrics
-	restartsTotal prometheus.Counter
+	restartsTotal *prometheus.CounterVec
+	stateGauge    *prometheus.GaugeVec
 }
 
 // NewPlugin creates a new instance of a device plugin.
-func NewPlugin(resource, pluginDir string, dps v1beta1.DevicePluginServer, logger log.Logger, reg prometheus.Registerer) Plugin {
+func NewPlugin(resource, pluginDir string, dps v1beta1.DevicePluginServer, logger log.Logger, reg prometheus.Registerer, opts ...Option) Plugin {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	o := options{registrationMode: RegisterRegistrationMode}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	socketDir := pluginDir
+	if o.registrationMode == PluginWatcherRegistrationMode {
+		socketDir = o.registryDir
+	}
 	p := &plugin{
 		DevicePluginServer: dps,
 		resource:           resource,
 		pluginDir:          pluginDir,
-		socket:             filepath.Join(pluginDir, fmt.Sprintf("%s-%s-%d.sock", socketPrefix, base64.StdEncoding.EncodeToString([]byte(resource)), time.Now().Unix())),
+		socket:             filepath.Join(socketDir, fmt.Sprintf("%s-%s-%d.sock", socketPrefix, base64.StdEncoding.EncodeToString([]byte(resource)), time.Now().Unix())),
 		logger:             logger,
-		restartsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		options:            o,
+		restartsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "device_plugin_restarts_total",
 			Help: "The number of times that the device plugin has restarted.",
-		}),
+		}, []string{"reason"}),
+		stateGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "device_plugin_state",
+			Help: "The current stage in the lifecycle of the device plugin; the gauge for the current state is 1.",
+		}, []string{"state"}),
 	}
+	p.setState(StateServing)
 
 	if reg != nil {
-		reg.MustRegister(p.restartsTotal)
+		reg.MustRegister(p.restartsTotal, p.stateGauge)
 	}
 
 	return p
 }
 
-// Run runs the device plugin until the given context is cancelled.
-func (p *plugin) Run(ctx context.Context) error {
+// kubeletSocket returns the path to the kubelet's registration socket.
+func (p *plugin) kubeletSocket() string {
+	if p.kubeletSocketPath != "" {
+		return p.kubeletSocketPath
+	}
+	return filepath.Join(p.pluginDir, filepath.Base(v1beta1.KubeletSocket))
+}
+
+// Run runs the device plugin until the given context is cancelled
+// and the devices are drained.
+func (p *plugin) Run(parent context.Context) error {
+	// Keep serving after the parent context is cancelled until the devices are drained.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	defer cancel()
+	go func() {
+		select {
+		case <-parent.Done():
+			p.drain(ctx)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if l, ok := p.DevicePluginServer.(looper); ok {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			l.loop(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
+	// The kubelet socket only matters when registering through it;
+	// the plugin watcher finds the plugin socket on its own after kubelet restarts.
+	var kubeletSocket string
+	if p.registrationMode == RegisterRegistrationMode {
+		kubeletSocket = p.kubeletSocket()
+	}
+	sw, err := newSocketWatcher(p.socket, kubeletSocket, p.handoffMarker(), p.logger)
+	if err != nil {
+		_ = level.Warn(p.logger).Log("msg", "falling back to polling the plugin socket; upgrades will not be handed off", "err", err)
+	} else {
+		defer func() { _ = sw.Close() }()
+	}
+	b := newBackoff(initialRestartInterval, maxRestartInterval)
 Outer:
 	for {
 		select {
 		case <-ctx.Done():
 			break Outer
 		default:
-			if err := p.runOnce(ctx); err != nil {
-				_ = level.Warn(p.logger).Log("msg", "encountered error while running plugin; trying again in 5 seconds", "err", err)
-				select {
-				case <-ctx.Done():
-					break Outer
-				case <-time.After(restartInterval):
-					p.restartsTotal.Inc()
-				}
+			p.setState(StateServing)
+			err := p.runOnce(ctx, sw, b)
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, errHandedOff) {
+				// Another instance took over the resource, so there
+				// is nothing left to drain; wait to be stopped.
+				_ = level.Info(p.logger).Log("msg", "stopped serving after handing off to another instance", "err", err)
+				p.setState(StateHandedOff)
+				<-ctx.Done()
+				break Outer
+			}
+			var re *restartError
+			if errors.As(err, &re) {
+				_ = level.Info(p.logger).Log("msg", "restarting plugin", "reason", re.reason, "err", re.err)
+				p.restartsTotal.WithLabelValues(re.reason).Inc()
+				continue
+			}
+			if errors.Is(err, errKubeletUnreachable) {
+				p.setState(StateKubeletUnreachable)
+			} else {
+				p.setState(StateBackingOff)
+			}
+			d := b.delay()
+			_ = level.Warn(p.logger).Log("msg", "encountered error while running plugin; trying again", "in", d, "err", err)
+			if !p.wait(ctx, sw, d) {
+				break Outer
 			}
 		}
 	}
 	return p.cleanUp()
 }
 
+// drain marks all devices unhealthy and waits for the drain grace period
+// or until the given context is cancelled.
+// Plugins that are not registered have nothing to drain.
+func (p *plugin) drain(ctx context.Context) {
+	d, ok := p.DevicePluginServer.(drainer)
+	if !ok || p.drainGracePeriod <= 0 || p.State() != StateRegistered {
+		return
+	}
+	p.setState(StateDraining)
+	_ = level.Info(p.logger).Log("msg", "draining devices", "grace", p.drainGracePeriod)
+	d.drain()
+	t := time.NewTimer(p.drainGracePeriod)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// State returns the current stage in the lifecycle of the plugin.
+func (p *plugin) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// FailingSince returns when the plugin started failing to register with the kubelet
+// without being registered since, or the zero time if it is not failing.
+// Retries go through the serving and registering states, which do not end the failure.
+func (p *plugin) FailingSince() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failingSince
+}
+
+func (p *plugin) setState(state State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != state {
+		_ = level.Debug(p.logger).Log("msg", "changing state", "from", p.state, "to", state)
+	}
+	p.state = state
+	switch state {
+	case StateKubeletUnreachable, StateBackingOff:
+		if p.failingSince.IsZero() {
+			p.failingSince = time.Now()
+		}
+	case StateRegistered, StateDraining, StateHandedOff:
+		p.failingSince = time.Time{}
+	}
+	for _, s := range States {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		p.stateGauge.WithLabelValues(string(s)).Set(v)
+	}
+}
+
+// wait waits for the given duration before the next run or until the kubelet restarts,
+// in which case there is no point in waiting any longer.
+// It returns false if the context is cancelled.
+func (p *plugin) wait(ctx context.Context, sw *socketWatcher, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+			p.restartsTotal.WithLabelValues(restartReasonError).Inc()
+			return true
+		case reason := <-sw.reasons():
+			if reason != restartReasonKubeletRestart {
+				continue
+			}
+			p.restartsTotal.WithLabelValues(reason).Inc()
+			return true
+		}
+	}
+}
+
 // serve starts the gRPC server and waits for it to be running
 // and accepting connections before returning. It returns a function
 // to wait for its completion as well as another to interrupt it.
@@ -154,9 +436,15 @@ Outer:
 
 // runOnce runs the plugin one time until an error is encountered,
 // until the socket is removed, or until the context is cancelled.
-func (p *plugin) runOnce(ctx context.Context) error {
+// A successful registration resets the given backoff.
+func (p *plugin) runOnce(ctx context.Context, sw *socketWatcher, b *backoff) error {
 	p.grpcServer = grpc.NewServer()
 	v1beta1.RegisterDevicePluginServer(p.grpcServer, p.DevicePluginServer)
+	var rs *registrationServer
+	if p.registrationMode == PluginWatcherRegistrationMode {
+		rs = newRegistrationServer(p.resource, p.logger)
+		pluginregistrationv1.RegisterRegistrationServer(p.grpcServer, rs)
+	}
 
 	var g run.Group
 	{
@@ -187,8 +475,39 @@ func (p *plugin) runOnce(ctx context.Context) error {
 				return fmt.Errorf("failed to close connection to local gRPC server: %v", err)
 			}
 			_ = level.Info(p.logger).Log("msg", "the gRPC server is ready")
-			if err := p.registerWithKubelet(); err != nil {
-				return fmt.Errorf("failed to register with kubelet: %v", err)
+			p.setState(StateRegistering)
+			if rs != nil {
+				// The kubelet's plugin watcher finds the socket on its own
+				// and reports the outcome of the registration, possibly
+				// multiple times, e.g. after kubelet restarts.
+				_ = level.Info(p.logger).Log("msg", "waiting for the kubelet plugin watcher to register the plugin", "socket", p.socket)
+				var advertised <-chan struct{}
+				for {
+					select {
+					case err := <-rs.failed:
+						return fmt.Errorf("failed to register with kubelet: %v", err)
+					case <-rs.registered:
+						p.setState(StateRegistered)
+						b.reset()
+						advertised = p.advertised()
+					case <-advertised:
+						p.announce()
+						advertised = nil
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			}
+			if err := p.registerWithKubelet(ctx); err != nil {
+				return fmt.Errorf("failed to register with kubelet: %w", err)
+			}
+			p.setState(StateRegistered)
+			b.reset()
+			select {
+			case <-p.advertised():
+				p.announce()
+			case <-ctx.Done():
+				return nil
 			}
 			<-ctx.Done()
 			return nil
@@ -199,34 +518,54 @@ func (p *plugin) runOnce(ctx context.Context) error {
 
 	{
 		// Watch the socket.
-		t := time.NewTicker(socketCheckInterval)
 		ctx, cancel := context.WithCancel(ctx)
+		t := time.NewTicker(socketCheckInterval)
 		defer t.Stop()
+		// Only poll when file-system notifications are unavailable.
+		ticks := t.C
+		if sw != nil {
+			t.Stop()
+			ticks = nil
+		}
 		g.Add(func() error {
 			for {
 				select {
-				case <-t.C:
+				case reason := <-sw.reasons():
+					// The notification may stem from the listener of a previous run removing
+					// the socket, so make sure that the current socket is really gone.
+					if reason == restartReasonSocketRemoved {
+						if _, err := os.Lstat(p.socket); err == nil {
+							continue
+						}
+					}
+					return &restartError{reason: reason, err: fmt.Errorf("received notification for socket %q", p.socket)}
+				case <-sw.handoffs():
+					if successor, ok := p.successor(); ok {
+						return fmt.Errorf("%w to %q", errHandedOff, successor)
+					}
+				case <-ticks:
 					if _, err := os.Lstat(p.socket); err != nil {
-						return fmt.Errorf("failed to stat plugin socket %q: %v", p.socket, err)
+						return &restartError{reason: restartReasonSocketRemoved, err: fmt.Errorf("failed to stat plugin socket %q: %v", p.socket, err)}
 					}
 				case <-ctx.Done():
 					return nil
 				}
-
 			}
 		}, func(error) {
 			cancel()
 		})
-
 	}
 
 	return g.Run()
 }
 
-func (p *plugin) registerWithKubelet() error {
+func (p *plugin) registerWithKubelet(ctx context.Context) error {
 	_ = level.Info(p.logger).Log("msg", "registering plugin with kubelet")
+	if _, err := os.Stat(p.kubeletSocket()); err != nil {
+		return fmt.Errorf("%w: %v", errKubeletUnreachable, err)
+	}
 	//nolint:all keep using deprecated gRPC functions for now
-	conn, err := grpc.Dial(filepath.Join(p.pluginDir, filepath.Base(v1beta1.KubeletSocket)), grpc.WithTransportCredentials(insecure.NewCredentials()),
+	conn, err := grpc.Dial(p.kubeletSocket(), grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
 			d := &net.Dialer{}
 			return d.DialContext(ctx, "unix", addr)
@@ -242,12 +581,219 @@ func (p *plugin) registerWithKubelet() error {
 		Endpoint:     filepath.Base(p.socket),
 		ResourceName: p.resource,
 	}
-	if _, err = client.Register(context.Background(), request); err != nil {
+	if _, err = client.Register(ctx, request); err != nil {
+		if status.Code(err) == codes.Unavailable {
+			return fmt.Errorf("%w: %v", errKubeletUnreachable, err)
+		}
 		return fmt.Errorf("failed to register plugin with kubelet service: %v", err)
 	}
 	return nil
 }
 
+// registrationServer implements the Registration service that the
+// kubelet's plugin watcher uses to discover and register plugins.
+type registrationServer struct {
+	pluginregistrationv1.UnimplementedRegistrationServer
+	resource string
+	logger   log.Logger
+	// failed receives the errors reported by the kubelet.
+	failed chan error
+	// registered receives a value whenever the kubelet reports a successful registration.
+	registered chan struct{}
+}
+
+func newRegistrationServer(resource string, logger log.Logger) *registrationServer {
+	return &registrationServer{
+		resource:   resource,
+		logger:     logger,
+		failed:     make(chan error, 1),
+		registered: make(chan struct{}, 1),
+	}
+}
+
+// GetInfo describes the plugin to the kubelet.
+// The endpoint is left empty so that the kubelet uses the same socket for the device plugin service.
+func (rs *registrationServer) GetInfo(_ context.Context, _ *pluginregistrationv1.InfoRequest) (*pluginregistrationv1.PluginInfo, error) {
+	return &pluginregistrationv1.PluginInfo{
+		Type:              pluginregistrationv1.DevicePlugin,
+		Name:              rs.resource,
+		SupportedVersions: []string{v1beta1.Version},
+	}, nil
+}
+
+// NotifyRegistrationStatus receives the outcome of the registration from the kubelet.
+func (rs *registrationServer) NotifyRegistrationStatus(_ context.Context, req *pluginregistrationv1.RegistrationStatus) (*pluginregistrationv1.RegistrationStatusResponse, error) {
+	if req.PluginRegistered {
+		_ = level.Info(rs.logger).Log("msg", "registered plugin with kubelet")
+		select {
+		case rs.registered <- struct{}{}:
+		default:
+		}
+		return &pluginregistrationv1.RegistrationStatusResponse{}, nil
+	}
+	select {
+	case rs.failed <- errors.New(req.Error):
+	default:
+	}
+	return &pluginregistrationv1.RegistrationStatusResponse{}, nil
+}
+
+// parseSocketName returns the resource name encoded in the name of a plugin socket.
+func parseSocketName(name string) (string, bool) {
+	name, ok := strings.CutPrefix(name, socketPrefix+"-")
+	if !ok {
+		return "", false
+	}
+	name, ok = strings.CutSuffix(name, ".sock")
+	if !ok {
+		return "", false
+	}
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(name[i+1:], 10, 64); err != nil {
+		return "", false
+	}
+	resource, err := base64.StdEncoding.DecodeString(name[:i])
+	if err != nil {
+		return "", false
+	}
+	return string(resource), true
+}
+
+// socketAlive returns true if something listens on the given Unix socket.
+func socketAlive(path string) bool {
+	c, err := net.DialTimeout("unix", path, socketDialTimeout)
+	if err != nil {
+		return false
+	}
+	_ = c.Close()
+	return true
+}
+
+// parseMarkerName returns the resource name encoded in the name of a handoff marker
+// or of a temporary file from which a marker is written.
+func parseMarkerName(name string) (string, bool) {
+	name, ok := strings.CutPrefix(name, socketPrefix+"-")
+	if !ok {
+		return "", false
+	}
+	name = strings.TrimSuffix(name, ".tmp")
+	name, ok = strings.CutSuffix(name, handoffSuffix)
+	if !ok {
+		return "", false
+	}
+	resource, err := base64.StdEncoding.DecodeString(name)
+	if err != nil {
+		return "", false
+	}
+	return string(resource), true
+}
+
+// CollectGarbage removes the plugin sockets in the given directory that earlier instances
+// of the plugin left behind, e.g. after crashing or for resources that were removed from the configuration,
+// as well as the handoff markers of resources that no instance serves anymore.
+// Sockets on which something still listens, and their markers, are kept.
+// It must be called once per process before any plugin starts.
+func CollectGarbage(dir string, logger log.Logger) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		_ = level.Warn(logger).Log("msg", "failed to list plugin sockets", "dir", dir, "err", err)
+		return
+	}
+	// live holds the resources that other running instances serve.
+	live := make(map[string]struct{})
+	for _, e := range entries {
+		if e.Type()&fs.ModeSocket == 0 {
+			continue
+		}
+		resource, ok := parseSocketName(e.Name())
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if socketAlive(path) {
+			_ = level.Debug(logger).Log("msg", "keeping socket of another running instance", "socket", path, "resource", resource)
+			live[resource] = struct{}{}
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			_ = level.Warn(logger).Log("msg", "failed to remove stale socket", "socket", path, "resource", resource, "err", err)
+			continue
+		}
+		_ = level.Info(logger).Log("msg", "removed stale socket", "socket", path, "resource", resource)
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		resource, ok := parseMarkerName(e.Name())
+		if !ok {
+			continue
+		}
+		if _, ok := live[resource]; ok {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			_ = level.Warn(logger).Log("msg", "failed to remove stale handoff marker", "marker", path, "resource", resource, "err", err)
+			continue
+		}
+		_ = level.Debug(logger).Log("msg", "removed stale handoff marker", "marker", path, "resource", resource)
+	}
+}
+
+// handoffMarker returns the path to the file through which
+// instances of the plugin announce that they took over the resource.
+func (p *plugin) handoffMarker() string {
+	return filepath.Join(filepath.Dir(p.socket), fmt.Sprintf("%s-%s%s", socketPrefix, base64.StdEncoding.EncodeToString([]byte(p.resource)), handoffSuffix))
+}
+
+// advertised returns a channel that is closed once the device plugin server advertised devices to the kubelet.
+// If the server cannot tell, then the channel is closed right away.
+func (p *plugin) advertised() <-chan struct{} {
+	if a, ok := p.DevicePluginServer.(advertiser); ok {
+		return a.advertised()
+	}
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// announce tells earlier instances of the plugin that this instance
+// is registered with the kubelet and advertised its devices, so that they can stop without draining.
+// The marker is replaced atomically, so that it is never read half-written.
+func (p *plugin) announce() {
+	marker := p.handoffMarker()
+	tmp := marker + ".tmp"
+	if err := os.WriteFile(tmp, []byte(filepath.Base(p.socket)), 0o600); err != nil {
+		_ = level.Warn(p.logger).Log("msg", "failed to write handoff marker", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, marker); err != nil {
+		_ = level.Warn(p.logger).Log("msg", "failed to write handoff marker", "err", err)
+		_ = os.Remove(tmp)
+	}
+}
+
+// successor returns the socket of the instance of the plugin that
+// announced itself last, if that instance is not this one.
+func (p *plugin) successor() (string, bool) {
+	buf, err := os.ReadFile(p.handoffMarker())
+	if err != nil {
+		return "", false
+	}
+	socket := strings.TrimSpace(string(buf))
+	if socket == "" || socket == filepath.Base(p.socket) {
+		return "", false
+	}
+	return socket, true
+}
+
 func (p *plugin) cleanUp() error {
 	if err := os.Remove(p.socket); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove socket: %v", err)
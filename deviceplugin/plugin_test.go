@@ -0,0 +1,342 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	pluginregistrationv1 "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+)
+
+func TestRegistrationServer(t *testing.T) {
+	rs := newRegistrationServer("squat.ai/serial", log.NewNopLogger())
+	info, err := rs.GetInfo(context.Background(), &pluginregistrationv1.InfoRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Type != pluginregistrationv1.DevicePlugin || info.Name != "squat.ai/serial" {
+		t.Errorf("expected device plugin %q; got %s plugin %q", "squat.ai/serial", info.Type, info.Name)
+	}
+	if len(info.SupportedVersions) != 1 || info.SupportedVersions[0] != v1beta1.Version {
+		t.Errorf("expected supported versions [%s]; got %v", v1beta1.Version, info.SupportedVersions)
+	}
+
+	if _, err := rs.NotifyRegistrationStatus(context.Background(), &pluginregistrationv1.RegistrationStatus{PluginRegistered: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case err := <-rs.failed:
+		t.Fatalf("unexpected registration failure: %v", err)
+	default:
+	}
+
+	// Repeated failures must not block the kubelet.
+	for range 2 {
+		if _, err := rs.NotifyRegistrationStatus(context.Background(), &pluginregistrationv1.RegistrationStatus{Error: "invalid resource name"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	select {
+	case err := <-rs.failed:
+		if err.Error() != "invalid resource name" {
+			t.Errorf("expected error %q; got %q", "invalid resource name", err)
+		}
+	default:
+		t.Error("expected registration failure to be reported")
+	}
+}
+
+func TestSocketWatcher(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "gdp-test.sock")
+	kubeletSocket := filepath.Join(dir, "kubelet.sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sw, err := newSocketWatcher(socket, kubeletSocket, filepath.Join(dir, "gdp-test.handoff"), log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = sw.Close() }()
+
+	expect := func(reason string) {
+		t.Helper()
+		select {
+		case r := <-sw.reasons():
+			if r != reason {
+				t.Errorf("expected reason %q; got %q", reason, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for reason %q", reason)
+		}
+	}
+
+	// Unrelated files are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "other.sock"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(socket); err != nil {
+		t.Fatal(err)
+	}
+	expect(restartReasonSocketRemoved)
+	if err := os.WriteFile(kubeletSocket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expect(restartReasonKubeletRestart)
+
+	if err := os.WriteFile(filepath.Join(dir, "gdp-test.handoff"), []byte("gdp-other.sock"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sw.handoffs():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for handoff")
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	dir := t.TempDir()
+	old := NewPlugin("squat.ai/serial", dir, nil, nil, nil).(*plugin)
+	old.socket = filepath.Join(dir, "gdp-old.sock")
+	successor := NewPlugin("squat.ai/serial", dir, nil, nil, nil).(*plugin)
+	successor.socket = filepath.Join(dir, "gdp-new.sock")
+	other := NewPlugin("squat.ai/video", dir, nil, nil, nil).(*plugin)
+
+	if _, ok := old.successor(); ok {
+		t.Error("expected no successor before any announcement")
+	}
+	old.announce()
+	if _, ok := old.successor(); ok {
+		t.Error("expected a plugin not to be its own successor")
+	}
+	successor.announce()
+	if s, ok := old.successor(); !ok || s != "gdp-new.sock" {
+		t.Errorf("expected successor %q; got %q", "gdp-new.sock", s)
+	}
+	if _, ok := other.successor(); ok {
+		t.Error("expected plugins of other resources not to be affected")
+	}
+	if _, err := os.Stat(successor.handoffMarker() + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected temporary marker to be removed; got %v", err)
+	}
+}
+
+func TestFailingSince(t *testing.T) {
+	p := NewPlugin("squat.ai/serial", t.TempDir(), nil, nil, nil).(*plugin)
+	if since := p.FailingSince(); !since.IsZero() {
+		t.Errorf("expected a new plugin not to be failing; failing since %s", since)
+	}
+	p.setState(StateKubeletUnreachable)
+	since := p.FailingSince()
+	if since.IsZero() {
+		t.Fatal("expected plugin to be failing")
+	}
+	// Retries do not end the failure.
+	for _, s := range []State{StateServing, StateRegistering, StateBackingOff, StateServing} {
+		p.setState(s)
+		if f := p.FailingSince(); !f.Equal(since) {
+			t.Errorf("%s: expected plugin to be failing since %s; got %s", s, since, f)
+		}
+	}
+	p.setState(StateRegistered)
+	if f := p.FailingSince(); !f.IsZero() {
+		t.Errorf("expected a registered plugin not to be failing; failing since %s", f)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Second, 10*time.Second)
+	for i, max := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		max *= time.Second
+		d := b.delay()
+		if d < max/2 || d > max {
+			t.Errorf("attempt %d: expected delay in [%s, %s]; got %s", i, max/2, max, d)
+		}
+	}
+	b.reset()
+	if d := b.delay(); d > time.Second {
+		t.Errorf("expected delay of at most 1s after reset; got %s", d)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	dir := t.TempDir()
+	name := func(resource string, ts int) string {
+		return filepath.Join(dir, fmt.Sprintf("%s-%s-%d.sock", socketPrefix, base64.StdEncoding.EncodeToString([]byte(resource)), ts))
+	}
+	// socket creates a Unix socket at the given path that is left behind when the listener closes.
+	socket := func(path string) net.Listener {
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		return l
+	}
+
+	live := socket(name("squat.ai/serial", 1))
+	defer func() { _ = live.Close() }()
+	_ = socket(name("squat.ai/serial", 2)).Close()
+	// Sockets of resources that are no longer configured are removed as well.
+	_ = socket(name("squat.ai/video", 3)).Close()
+	// Sockets of other device plugins are kept.
+	_ = socket(filepath.Join(dir, "other.sock")).Close()
+	if err := os.WriteFile(filepath.Join(dir, "kubelet_internal_checkpoint"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	marker := func(resource, suffix string) string {
+		return filepath.Join(dir, fmt.Sprintf("%s-%s%s", socketPrefix, base64.StdEncoding.EncodeToString([]byte(resource)), suffix))
+	}
+	// Markers are kept while an instance serves their resource.
+	for _, path := range []string{marker("squat.ai/serial", handoffSuffix), marker("squat.ai/video", handoffSuffix), marker("squat.ai/video", handoffSuffix+".tmp")} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	CollectGarbage(dir, nil)
+
+	for path, exists := range map[string]bool{
+		name("squat.ai/serial", 1):                        true,
+		name("squat.ai/serial", 2):                        false,
+		name("squat.ai/video", 3):                         false,
+		filepath.Join(dir, "other.sock"):                  true,
+		filepath.Join(dir, "kubelet_internal_checkpoint"): true,
+		marker("squat.ai/serial", handoffSuffix):          true,
+		marker("squat.ai/video", handoffSuffix):           false,
+		marker("squat.ai/video", handoffSuffix+".tmp"):    false,
+	} {
+		if _, err := os.Lstat(path); (err == nil) != exists {
+			t.Errorf("expected %q to exist: %t; got error %v", path, exists, err)
+		}
+	}
+}
+
+func TestParseSocketName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		resource string
+		ok       bool
+	}{
+		{
+			name:     fmt.Sprintf("gdp-%s-1700000000.sock", base64.StdEncoding.EncodeToString([]byte("squat.ai/fuse"))),
+			resource: "squat.ai/fuse",
+			ok:       true,
+		},
+		{
+			name: "kubelet.sock",
+		},
+		{
+			name: "gdp-!!!-1700000000.sock",
+		},
+		{
+			name: fmt.Sprintf("gdp-%s.sock", base64.StdEncoding.EncodeToString([]byte("squat.ai/fuse"))),
+		},
+	} {
+		resource, ok := parseSocketName(tc.name)
+		if ok != tc.ok || resource != tc.resource {
+			t.Errorf("%s: expected (%q, %t); got (%q, %t)", tc.name, tc.resource, tc.ok, resource, ok)
+		}
+	}
+}
+
+func TestDetectPluginDir(t *testing.T) {
+	missing, dead, live := t.TempDir(), t.TempDir(), t.TempDir()
+	l, err := net.Listen("unix", filepath.Join(dead, "kubelet.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+	l, err = net.Listen("unix", filepath.Join(live, "kubelet.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	dir, err := DetectPluginDir([]string{missing, dead, live})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dir != live {
+		t.Errorf("expected %q; got %q", live, dir)
+	}
+	if _, err := DetectPluginDir([]string{missing, dead}); err == nil {
+		t.Error("expected an error when no kubelet is listening")
+	}
+}
+
+// fakeDrainer counts how often it is drained.
+type fakeDrainer struct {
+	v1beta1.UnimplementedDevicePluginServer
+	drained int
+}
+
+func (d *fakeDrainer) drain() {
+	d.drained++
+}
+
+func TestPluginDrain(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		state   State
+		grace   time.Duration
+		drained int
+	}{
+		{
+			name:    "registered",
+			state:   StateRegistered,
+			grace:   10 * time.Millisecond,
+			drained: 1,
+		},
+		{
+			name:  "not registered",
+			state: StateBackingOff,
+			grace: 10 * time.Millisecond,
+		},
+		{
+			name:  "disabled",
+			state: StateRegistered,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := new(fakeDrainer)
+			p := NewPlugin("squat.ai/fuse", t.TempDir(), d, nil, nil, WithDrainGracePeriod(tc.grace)).(*plugin)
+			p.setState(tc.state)
+			start := time.Now()
+			p.drain(context.Background())
+			if d.drained != tc.drained {
+				t.Errorf("expected %d drains; got %d", tc.drained, d.drained)
+			}
+			if tc.drained > 0 {
+				if elapsed := time.Since(start); elapsed < tc.grace {
+					t.Errorf("expected to wait for %s; waited %s", tc.grace, elapsed)
+				}
+				if s := p.State(); s != StateDraining {
+					t.Errorf("expected state %q; got %q", StateDraining, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	initialRestartInterval = 1 * time.Second
+	maxRestartInterval     = 2 * time.Minute
+)
+
+var (
+	// errKubeletUnreachable signals that the kubelet could not be contacted.
+	errKubeletUnreachable = errors.New("kubelet is unreachable")
+	// errHandedOff signals that a newer instance of the plugin took over the resource.
+	errHandedOff = errors.New("handed off")
+)
+
+// State is a stage in the lifecycle of a plugin.
+type State string
+
+const (
+	// StateServing means that the plugin is starting its gRPC server.
+	StateServing State = "serving"
+	// StateRegistering means that the plugin is waiting to be registered with the kubelet.
+	StateRegistering State = "registering"
+	// StateRegistered means that the kubelet accepted the plugin.
+	StateRegistered State = "registered"
+	// StateKubeletUnreachable means that the plugin could not contact the kubelet and will try again.
+	StateKubeletUnreachable State = "kubelet-unreachable"
+	// StateBackingOff means that the plugin encountered an error and will try again.
+	StateBackingOff State = "backing-off"
+	// StateDraining means that the plugin reported all devices as unhealthy and is about to stop.
+	StateDraining State = "draining"
+	// StateHandedOff means that a newer instance of the plugin took over and this one stopped serving.
+	StateHandedOff State = "handed-off"
+)
+
+// States is the list of all states of a plugin.
+var States = []State{StateServing, StateRegistering, StateRegistered, StateKubeletUnreachable, StateBackingOff, StateDraining, StateHandedOff}
+
+// backoff computes exponentially growing delays with jitter.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	next    time.Duration
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	return &backoff{initial: initial, max: max, next: initial}
+}
+
+// delay returns the time to wait before the next attempt.
+// Up to half of the delay is random, so that plugins do not retry in lockstep.
+func (b *backoff) delay() time.Duration {
+	d := b.next
+	b.next = min(2*b.next, b.max)
+	return d/2 + rand.N(d/2+1)
+}
+
+// reset makes the next delay the initial one again.
+func (b *backoff) reset() {
+	b.next = b.initial
+}
@@ -0,0 +1,223 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errNoDeviceNode is returned for sysfs devices that do not have a device node.
+var errNoDeviceNode = errors.New("no device node")
+
+// The subsystems of device nodes in /sys/dev.
+const (
+	charSubsystem  = "char"
+	blockSubsystem = "block"
+)
+
+// Range is an inclusive range of integers.
+// Either bound can be left unspecified to leave the range open on that side.
+type Range struct {
+	Min *int64 `json:"min,omitempty"`
+	Max *int64 `json:"max,omitempty"`
+}
+
+// contains reports whether the given value is within the range.
+func (r *Range) contains(v int64) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// validate checks that the range is not empty.
+func (r *Range) validate() error {
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("range minimum %d is greater than its maximum %d", *r.Min, *r.Max)
+	}
+	return nil
+}
+
+// DeviceSelector selects device nodes by their device numbers and kernel drivers instead of their paths,
+// for devices whose names are unpredictable but whose numbers or drivers are well known.
+// Device nodes must match all of the specified criteria.
+type DeviceSelector struct {
+	// Subsystem restricts the selector to device nodes of one subsystem.
+	// This can be one of:
+	// * char - character devices; or
+	// * block - block devices.
+	// When unspecified, both character and block devices are selected.
+	Subsystem string `json:"subsystem,omitempty"`
+	// Major is the major number of the device nodes, e.g. 166 for ttyACM devices.
+	Major *uint32 `json:"major,omitempty"`
+	// Minor is the range of the minor numbers of the device nodes.
+	Minor *Range `json:"minor,omitempty"`
+	// Drivers is a list of kernel drivers, one of which must be bound to the device of the nodes, e.g. cdc_acm.
+	Drivers []string `json:"drivers,omitempty"`
+}
+
+// validate checks that the selector is valid.
+func (s *DeviceSelector) validate() error {
+	switch s.Subsystem {
+	case "", charSubsystem, blockSubsystem:
+	default:
+		return fmt.Errorf("unknown subsystem %q", s.Subsystem)
+	}
+	if s.Major == nil && s.Minor == nil && len(s.Drivers) == 0 {
+		return errors.New("device selector must specify a major number, a minor range, or drivers")
+	}
+	if s.Minor != nil {
+		if err := s.Minor.validate(); err != nil {
+			return fmt.Errorf("invalid minor numbers: %w", err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the given device node is selected.
+func (s *DeviceSelector) matches(d sysDevice) bool {
+	if s.Subsystem != "" && s.Subsystem != d.subsystem {
+		return false
+	}
+	if s.Major != nil && *s.Major != d.major {
+		return false
+	}
+	if s.Minor != nil && !s.Minor.contains(int64(d.minor)) {
+		return false
+	}
+	return len(s.Drivers) == 0 || slices.Contains(s.Drivers, d.driver)
+}
+
+// String describes the selector, e.g. in logs and metrics.
+func (s *DeviceSelector) String() string {
+	var criteria []string
+	if s.Subsystem != "" {
+		criteria = append(criteria, "subsystem="+s.Subsystem)
+	}
+	if s.Major != nil {
+		criteria = append(criteria, fmt.Sprintf("major=%d", *s.Major))
+	}
+	if s.Minor != nil {
+		var min, max string
+		if s.Minor.Min != nil {
+			min = strconv.FormatInt(*s.Minor.Min, 10)
+		}
+		if s.Minor.Max != nil {
+			max = strconv.FormatInt(*s.Minor.Max, 10)
+		}
+		criteria = append(criteria, fmt.Sprintf("minor=%s-%s", min, max))
+	}
+	if len(s.Drivers) > 0 {
+		criteria = append(criteria, "drivers="+strings.Join(s.Drivers, ","))
+	}
+	return "device(" + strings.Join(criteria, " ") + ")"
+}
+
+// sysDevice is a device node listed in /sys/dev.
+type sysDevice struct {
+	subsystem string
+	major     uint32
+	minor     uint32
+	// node is the path of the device node in /dev.
+	node string
+	// driver is the name of the kernel driver bound to the device, if any.
+	driver string
+}
+
+// devNode returns the path of the device node of the sysfs device in the given directory
+// from the DEVNAME line of its uevent file.
+func devNode(ctx context.Context, fsys fs.FS, dir string) (string, error) {
+	data, err := readFile(ctx, fsys, path.Join(dir, "uevent"))
+	if err != nil {
+		return "", err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if name, ok := strings.CutPrefix(s.Text(), "DEVNAME="); ok {
+			return path.Join("/dev", name), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%w: %q has no DEVNAME", errNoDeviceNode, dir)
+}
+
+// driver returns the name of the kernel driver bound to the sysfs device in the given directory
+// or an empty string if no driver is bound.
+func driver(fsys fs.FS, dir string) string {
+	target, err := fs.ReadLink(fsys, path.Join(dir, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// enumerateSysDevices returns the character and block device nodes listed in the given directory,
+// usually /sys/dev, along with the entries that were skipped.
+func enumerateSysDevices(ctx context.Context, fsys fs.FS, dir string) ([]sysDevice, []diagnostic, error) {
+	var devices []sysDevice
+	var diagnostics []diagnostic
+	for _, subsystem := range []string{charSubsystem, blockSubsystem} {
+		entries, err := readDir(ctx, fsys, path.Join(dir, subsystem))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, nil, fmt.Errorf("failed to list %s devices: %w", subsystem, err)
+		}
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return devices, diagnostics, err
+			}
+			entry := path.Join(dir, subsystem, e.Name())
+			d := sysDevice{subsystem: subsystem}
+			if _, err := fmt.Sscanf(e.Name(), "%d:%d", &d.major, &d.minor); err != nil {
+				err = fmt.Errorf("%w: %q is not a device number", errMalformedAttribute, e.Name())
+				diagnostics = append(diagnostics, diagnostic{source: sysDevSource, path: entry, reason: skipReason(err), err: err})
+				continue
+			}
+			if d.node, err = devNode(ctx, fsys, entry); err != nil {
+				diagnostics = append(diagnostics, diagnostic{source: sysDevSource, path: entry, reason: skipReason(err), err: err})
+				continue
+			}
+			d.driver = driver(fsys, entry)
+			devices = append(devices, d)
+		}
+	}
+	return devices, diagnostics, nil
+}
+
+// selectDevices returns the paths of the device nodes selected by the given selector.
+func (gp *GenericPlugin) selectDevices(ctx context.Context, s *DeviceSelector) ([]string, error) {
+	devices, err := gp.sysDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, d := range devices {
+		if s.matches(d) {
+			paths = append(paths, d.node)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/squat/generic-device-plugin/absolute"
+)
+
+// sysDevFS returns a file system with two ttyACM devices bound to the cdc_acm driver,
+// an FTDI serial converter bound to the ftdi_sio driver, a disk, and the nodes in /dev.
+func sysDevFS() fstest.MapFS {
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	uevent := func(name string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte("MAJOR=0\nMINOR=0\nDEVNAME=" + name + "\n")}
+	}
+	return fstest.MapFS{
+		"sys/dev/char/166:0": link("../../devices/usb/1-1/1-1:1.0/tty/ttyACM0"),
+		"sys/dev/char/166:1": link("../../devices/usb/1-2/1-2:1.0/tty/ttyACM1"),
+		"sys/dev/char/188:0": link("../../devices/usb/1-3/1-3:1.0/ttyUSB0/tty/ttyUSB0"),
+		"sys/dev/char/1:3":   link("../../devices/virtual/mem/null"),
+		"sys/dev/char/foo":   link("../../devices/virtual/foo"),
+		"sys/dev/block/8:0":  link("../../devices/pci/ata1/block/sda"),
+		"sys/devices/usb/1-1/1-1:1.0/tty/ttyACM0/uevent":         uevent("ttyACM0"),
+		"sys/devices/usb/1-1/1-1:1.0/tty/ttyACM0/device":         link("../../../1-1:1.0"),
+		"sys/devices/usb/1-1/1-1:1.0/driver":                     link("../../../../bus/usb/drivers/cdc_acm"),
+		"sys/devices/usb/1-2/1-2:1.0/tty/ttyACM1/uevent":         uevent("ttyACM1"),
+		"sys/devices/usb/1-2/1-2:1.0/tty/ttyACM1/device":         link("../../../1-2:1.0"),
+		"sys/devices/usb/1-2/1-2:1.0/driver":                     link("../../../../bus/usb/drivers/cdc_acm"),
+		"sys/devices/usb/1-3/1-3:1.0/ttyUSB0/tty/ttyUSB0/uevent": uevent("ttyUSB0"),
+		"sys/devices/usb/1-3/1-3:1.0/ttyUSB0/tty/ttyUSB0/device": link("../../../ttyUSB0"),
+		"sys/devices/usb/1-3/1-3:1.0/ttyUSB0/driver":             link("../../../../../bus/usb-serial/drivers/ftdi_sio"),
+		"sys/devices/virtual/mem/null/uevent":                    uevent("null"),
+		"sys/devices/virtual/foo/uevent":                         {Data: []byte("MAJOR=0\n")},
+		"sys/devices/pci/ata1/block/sda/uevent":                  uevent("sda"),
+		"dev/ttyACM0":                                            charDevice,
+		"dev/ttyACM1":                                            charDevice,
+		"dev/ttyUSB0":                                            charDevice,
+		"dev/null":                                               charDevice,
+		"dev/sda":                                                blockDevice,
+	}
+}
+
+func TestEnumerateSysDevices(t *testing.T) {
+	devices, diagnostics, err := enumerateSysDevices(context.Background(), absolute.New(sysDevFS(), "/"), sysDevDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []sysDevice{
+		{subsystem: "char", major: 166, minor: 0, node: "/dev/ttyACM0", driver: "cdc_acm"},
+		{subsystem: "char", major: 166, minor: 1, node: "/dev/ttyACM1", driver: "cdc_acm"},
+		{subsystem: "char", major: 188, minor: 0, node: "/dev/ttyUSB0", driver: "ftdi_sio"},
+		{subsystem: "char", major: 1, minor: 3, node: "/dev/null"},
+		{subsystem: "block", major: 8, minor: 0, node: "/dev/sda"},
+	}
+	for _, d := range expected {
+		if !slices.Contains(devices, d) {
+			t.Errorf("expected device %+v; got %+v", d, devices)
+		}
+	}
+	if len(devices) != len(expected) {
+		t.Errorf("expected %d devices; got %d", len(expected), len(devices))
+	}
+	if len(diagnostics) != 1 || diagnostics[0].path != "/sys/dev/char/foo" || diagnostics[0].reason != MalformedSkipReason {
+		t.Errorf("expected /sys/dev/char/foo to be malformed; got %+v", diagnostics)
+	}
+}
+
+func TestDeviceSelector(t *testing.T) {
+	major := func(v uint32) *uint32 { return &v }
+	bound := func(v int64) *int64 { return &v }
+	for _, tc := range []struct {
+		name     string
+		selector *DeviceSelector
+		paths    []string
+		err      bool
+	}{
+		{
+			name:     "major",
+			selector: &DeviceSelector{Major: major(166)},
+			paths:    []string{"/dev/ttyACM0", "/dev/ttyACM1"},
+		},
+		{
+			name:     "minor range",
+			selector: &DeviceSelector{Major: major(166), Minor: &Range{Min: bound(1)}},
+			paths:    []string{"/dev/ttyACM1"},
+		},
+		{
+			name:     "drivers",
+			selector: &DeviceSelector{Drivers: []string{"cdc_acm", "ftdi_sio"}},
+			paths:    []string{"/dev/ttyACM0", "/dev/ttyACM1", "/dev/ttyUSB0"},
+		},
+		{
+			name:     "subsystem",
+			selector: &DeviceSelector{Subsystem: "block", Minor: &Range{Max: bound(0)}},
+			paths:    []string{"/dev/sda"},
+		},
+		{
+			name:     "no criteria",
+			selector: &DeviceSelector{Subsystem: "char"},
+			err:      true,
+		},
+		{
+			name:     "empty minor range",
+			selector: &DeviceSelector{Minor: &Range{Min: bound(2), Max: bound(1)}},
+			err:      true,
+		},
+		{
+			name:     "unknown subsystem",
+			selector: &DeviceSelector{Subsystem: "net", Major: major(1)},
+			err:      true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.selector.validate(); (err != nil) != tc.err {
+				t.Fatalf("expected error %t; got %v", tc.err, err)
+			}
+			if tc.err {
+				return
+			}
+			gp := newTestGenericPlugin(t, &DeviceSpec{Name: "serial"}, sysDevFS())
+			paths, err := gp.selectDevices(context.Background(), tc.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			slices.Sort(paths)
+			if !slices.Equal(paths, tc.paths) {
+				t.Errorf("expected paths %v; got %v", tc.paths, paths)
+			}
+		})
+	}
+}
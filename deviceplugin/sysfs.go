@@ -0,0 +1,318 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+)
+
+// sysClassDir is the directory in which sysfs lists the devices of every class.
+const sysClassDir = "/sys/class/"
+
+// SysfsSpec selects the device nodes of a sysfs class by the attributes of their devices,
+// e.g. the video4linux devices whose index is 0, which are not metadata nodes.
+// The device nodes are resolved through the DEVNAME in the uevent files of the devices
+// and are mounted like the matches of a Path of type Device.
+type SysfsSpec struct {
+	// Class is the name of the sysfs class of the devices, i.e. a directory in /sys/class, e.g. video4linux or hidraw.
+	Class string `json:"class"`
+	// Attributes is a list of predicates that the attributes of a device must all satisfy for its node to be selected.
+	Attributes []*AttributePredicate `json:"attributes,omitempty"`
+	// MountPath is the file path at which the device node should be mounted within the container.
+	// MountPath is a Go template with the same fields as the MountPath of a Path.
+	// When unspecified, MountPath defaults to the path of the device node.
+	MountPath string `json:"mountPath,omitempty"`
+	// Permissions is the file-system permissions given to the mounted device, like the Permissions of a Path.
+	// When unspecified, Permissions defaults to mrw.
+	Permissions string `json:"permissions,omitempty"`
+	// Optional specifies whether the group can do without devices of this class, like the Optional of a Path.
+	// When unspecified, Optional defaults to false.
+	Optional bool `json:"optional,omitempty"`
+
+	path *Path
+}
+
+// AttributePredicate is a condition on the value of an attribute of a sysfs device.
+// Values are compared without surrounding whitespace and exactly one condition must be specified.
+// Devices whose attribute cannot be read do not satisfy the predicate.
+type AttributePredicate struct {
+	// Name is the path of the attribute relative to the directory of the device, e.g. name, index, or device/modalias.
+	Name string `json:"name"`
+	// Equals is the exact value of the attribute.
+	Equals *AttributeValue `json:"equals,omitempty"`
+	// Glob is a pattern in the syntax of path.Match that the value of the attribute must match, e.g. usb:v046Dp*.
+	Glob string `json:"glob,omitempty"`
+	// Regex is a regular expression that must match the entire value of the attribute.
+	Regex string `json:"regex,omitempty"`
+	// Range is the range of integers, which may be written in decimal, hexadecimal with 0x, or octal with 0,
+	// in which the value of the attribute must be.
+	Range *Range `json:"range,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// AttributeValue is the value of a sysfs attribute.
+// In configuration, it can be written as a string, a number, or a boolean, e.g. 0 or "0".
+type AttributeValue string
+
+// UnmarshalJSON handles attribute values given as strings, numbers, or booleans.
+func (v *AttributeValue) UnmarshalJSON(data []byte) error {
+	str := strings.TrimSpace(string(data))
+	if str == "null" {
+		return nil
+	}
+	if strings.HasPrefix(str, `"`) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("malformed attribute value %s: %w", str, err)
+		}
+		*v = AttributeValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*v = AttributeValue(n)
+		return nil
+	}
+	var b bool
+	if err := json.Unmarshal(data, &b); err != nil {
+		return fmt.Errorf("malformed attribute value %s: expected a string, number, or boolean", str)
+	}
+	*v = AttributeValue(strconv.FormatBool(b))
+	return nil
+}
+
+// ToAttributeValueHookFunc handles mapstructure decode of attribute values given as numbers or booleans.
+func ToAttributeValueHookFunc(f, t reflect.Type, data interface{}) (interface{}, error) {
+	if t != reflect.TypeOf(AttributeValue("")) {
+		return data, nil
+	}
+
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		return fmt.Sprint(data), nil
+	default:
+		return data, nil
+	}
+}
+
+// validate checks that the predicate names an attribute and has exactly one valid condition.
+func (a *AttributePredicate) validate() error {
+	if a.Name == "" || !fs.ValidPath(a.Name) || a.Name == "." {
+		return fmt.Errorf("invalid attribute name %q", a.Name)
+	}
+	var conditions int
+	if a.Equals != nil {
+		conditions++
+	}
+	if a.Glob != "" {
+		conditions++
+		if _, err := path.Match(a.Glob, ""); err != nil {
+			return fmt.Errorf("failed to parse %q: %w", a.Glob, err)
+		}
+	}
+	if a.Regex != "" {
+		conditions++
+		re, err := compileRegexp(a.Regex)
+		if err != nil {
+			return err
+		}
+		a.re = re
+	}
+	if a.Range != nil {
+		conditions++
+		if err := a.Range.validate(); err != nil {
+			return err
+		}
+	}
+	if conditions != 1 {
+		return fmt.Errorf("attribute %q must have exactly one of equals, glob, regex, or range", a.Name)
+	}
+	return nil
+}
+
+// matches reports whether the given value of the attribute satisfies the predicate.
+func (a *AttributePredicate) matches(value string) bool {
+	switch {
+	case a.Equals != nil:
+		return value == string(*a.Equals)
+	case a.Glob != "":
+		ok, _ := path.Match(a.Glob, value)
+		return ok
+	case a.Regex != "":
+		re := a.re
+		if re == nil {
+			// Predicates are shared between plugins, so only validate stores the compiled expression.
+			var err error
+			if re, err = compileRegexp(a.Regex); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(value)
+	case a.Range != nil:
+		v, err := strconv.ParseInt(value, 0, 64)
+		return err == nil && a.Range.contains(v)
+	}
+	return false
+}
+
+// String describes the predicate, e.g. index==0.
+func (a *AttributePredicate) String() string {
+	switch {
+	case a.Equals != nil:
+		return fmt.Sprintf("%s==%s", a.Name, *a.Equals)
+	case a.Glob != "":
+		return fmt.Sprintf("%s=~%s", a.Name, a.Glob)
+	case a.Regex != "":
+		return fmt.Sprintf("%s=~/%s/", a.Name, a.Regex)
+	case a.Range != nil:
+		var min, max string
+		if a.Range.Min != nil {
+			min = strconv.FormatInt(*a.Range.Min, 10)
+		}
+		if a.Range.Max != nil {
+			max = strconv.FormatInt(*a.Range.Max, 10)
+		}
+		return fmt.Sprintf("%s in %s-%s", a.Name, min, max)
+	}
+	return a.Name
+}
+
+// validate checks that the class and attribute predicates of the spec are valid.
+func (s *SysfsSpec) validate() error {
+	if s.Class == "" || strings.Contains(s.Class, "/") || s.Class == "." || s.Class == ".." {
+		return fmt.Errorf("invalid sysfs class %q", s.Class)
+	}
+	for _, a := range s.Attributes {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("invalid predicate for sysfs class %q: %w", s.Class, err)
+		}
+	}
+	return s.asPath().parseMountPath()
+}
+
+// String describes the spec, e.g. in logs and metrics.
+func (s *SysfsSpec) String() string {
+	criteria := []string{"class=" + s.Class}
+	for _, a := range s.Attributes {
+		criteria = append(criteria, a.String())
+	}
+	return "sysfs(" + strings.Join(criteria, " ") + ")"
+}
+
+// asPath returns the Path whose matches are the device nodes selected by the spec,
+// so that they are grouped and mounted like the matches of any other Path.
+func (s *SysfsSpec) asPath() *Path {
+	if s.path == nil {
+		permissions := s.Permissions
+		if permissions == "" {
+			permissions = "mrw"
+		}
+		s.path = &Path{
+			MountPath:   s.MountPath,
+			Permissions: permissions,
+			Optional:    s.Optional,
+			Type:        DevicePathType,
+			Match:       GlobMatchMode,
+			Limit:       1,
+			sysfs:       s,
+		}
+	}
+	return s.path
+}
+
+// paths returns the Paths of the group followed by the Paths of its sysfs specs.
+func (g *Group) paths() []*Path {
+	if len(g.Sysfs) == 0 {
+		return g.Paths
+	}
+	paths := slices.Clone(g.Paths)
+	for _, s := range g.Sysfs {
+		paths = append(paths, s.asPath())
+	}
+	return paths
+}
+
+// sysfsDevice is a device of a sysfs class that has a device node.
+type sysfsDevice struct {
+	// dir is the directory of the device in the class, e.g. /sys/class/video4linux/video0.
+	dir string
+	// node is the path of the device node in /dev.
+	node string
+}
+
+// enumerateSysfsClass returns the devices of the given class in the given directory, usually /sys/class,
+// that have device nodes, along with the entries that were skipped.
+func enumerateSysfsClass(ctx context.Context, fsys fs.FS, dir, class string) ([]sysfsDevice, []diagnostic, error) {
+	entries, err := readDir(ctx, fsys, path.Join(dir, class))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to list devices of sysfs class %q: %w", class, err)
+	}
+	var devices []sysfsDevice
+	var diagnostics []diagnostic
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return devices, diagnostics, err
+		}
+		d := sysfsDevice{dir: path.Join(dir, class, e.Name())}
+		if d.node, err = devNode(ctx, fsys, d.dir); err != nil {
+			diagnostics = append(diagnostics, diagnostic{source: sysfsSource, path: d.dir, reason: skipReason(err), err: err})
+			continue
+		}
+		devices = append(devices, d)
+	}
+	return devices, diagnostics, nil
+}
+
+// selectSysfs returns the paths of the device nodes of the devices that satisfy all of the predicates of the given spec.
+func (gp *GenericPlugin) selectSysfs(ctx context.Context, s *SysfsSpec) ([]string, error) {
+	devices, err := gp.sysfsDevices(ctx, s.Class)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+Devices:
+	for _, d := range devices {
+		for _, a := range s.Attributes {
+			value, err := readFile(ctx, gp.fs, path.Join(d.dir, a.Name))
+			if err != nil {
+				_ = level.Debug(gp.logger).Log("msg", "failed to read sysfs attribute", "device", d.dir, "attribute", a.Name, "err", err)
+				continue Devices
+			}
+			if !a.matches(strings.TrimSpace(string(value))) {
+				continue Devices
+			}
+		}
+		paths = append(paths, d.node)
+	}
+	return paths, nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// sysfsFS returns a file system with two video4linux cameras, each with a capture node and a metadata node,
+// an IIO sensor, a network interface without a device node, and the nodes in /dev.
+func sysfsFS() fstest.MapFS {
+	fsys := fstest.MapFS{
+		"sys/class/net/eth0":                      {Data: []byte("../../devices/pci/net/eth0"), Mode: fs.ModeSymlink},
+		"sys/devices/pci/net/eth0/uevent":         {Data: []byte("INTERFACE=eth0\nIFINDEX=2\n")},
+		"sys/class/iio/iio:device0":               {Data: []byte("../../devices/i2c/iio:device0"), Mode: fs.ModeSymlink},
+		"sys/devices/i2c/iio:device0/uevent":      {Data: []byte("MAJOR=241\nMINOR=0\nDEVNAME=iio:device0\n")},
+		"sys/devices/i2c/iio:device0/name":        {Data: []byte("bme280\n")},
+		"sys/devices/i2c/iio:device0/in_temp_raw": {Data: []byte("23100\n")},
+		"dev/iio:device0":                         charDevice,
+	}
+	for i, camera := range []string{"046d:0825", "046d:082d"} {
+		for index := range 2 {
+			node := fmt.Sprintf("video%d", 2*i+index)
+			dir := fmt.Sprintf("devices/usb/1-%d/video4linux/%s", i+1, node)
+			fsys["sys/class/video4linux/"+node] = &fstest.MapFile{Data: []byte("../../" + dir), Mode: fs.ModeSymlink}
+			fsys["sys/"+dir+"/uevent"] = &fstest.MapFile{Data: []byte("MAJOR=81\nDEVNAME=" + node + "\n")}
+			fsys["sys/"+dir+"/index"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", index))}
+			fsys["sys/"+dir+"/name"] = &fstest.MapFile{Data: []byte("HD Webcam " + camera + "\n")}
+			fsys["dev/"+node] = charDevice
+		}
+	}
+	return fsys
+}
+
+func TestAttributePredicate(t *testing.T) {
+	zero := AttributeValue("0")
+	bound := func(v int64) *int64 { return &v }
+	for _, tc := range []struct {
+		name      string
+		predicate *AttributePredicate
+		matches   map[string]bool
+		err       bool
+	}{
+		{
+			name:      "equals",
+			predicate: &AttributePredicate{Name: "index", Equals: &zero},
+			matches:   map[string]bool{"0": true, "1": false, "": false},
+		},
+		{
+			name:      "glob",
+			predicate: &AttributePredicate{Name: "device/modalias", Glob: "usb:v046Dp*"},
+			matches:   map[string]bool{"usb:v046Dp0825d0012": true, "usb:v1A86p7523": false},
+		},
+		{
+			name:      "regex",
+			predicate: &AttributePredicate{Name: "name", Regex: "bme[0-9]+"},
+			matches:   map[string]bool{"bme280": true, "bme280x": false, "xbme280": false},
+		},
+		{
+			name:      "range",
+			predicate: &AttributePredicate{Name: "in_temp_raw", Range: &Range{Min: bound(0), Max: bound(0x10)}},
+			matches:   map[string]bool{"0": true, "16": true, "0x10": true, "17": false, "-1": false, "warm": false},
+		},
+		{
+			name:      "no condition",
+			predicate: &AttributePredicate{Name: "index"},
+			err:       true,
+		},
+		{
+			name:      "two conditions",
+			predicate: &AttributePredicate{Name: "index", Equals: &zero, Glob: "0"},
+			err:       true,
+		},
+		{
+			name:      "attribute outside of the device",
+			predicate: &AttributePredicate{Name: "../video1/index", Equals: &zero},
+			err:       true,
+		},
+		{
+			name:      "malformed regex",
+			predicate: &AttributePredicate{Name: "name", Regex: "("},
+			err:       true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.predicate.validate(); (err != nil) != tc.err {
+				t.Fatalf("expected error %t; got %v", tc.err, err)
+			}
+			for value, expected := range tc.matches {
+				if ok := tc.predicate.matches(value); ok != expected {
+					t.Errorf("%q: expected match %t; got %t", value, expected, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestAttributePredicateConcurrent(t *testing.T) {
+	// Predicates are shared between the plugins of a process, which match them concurrently.
+	predicate := &AttributePredicate{Name: "name", Regex: "bme[0-9]+"}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			if !predicate.matches("bme280") {
+				t.Error("expected bme280 to match")
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestSelectSysfs(t *testing.T) {
+	zero := AttributeValue("0")
+	for _, tc := range []struct {
+		name  string
+		spec  *SysfsSpec
+		paths []string
+	}{
+		{
+			name:  "class",
+			spec:  &SysfsSpec{Class: "video4linux"},
+			paths: []string{"/dev/video0", "/dev/video1", "/dev/video2", "/dev/video3"},
+		},
+		{
+			name:  "capture nodes",
+			spec:  &SysfsSpec{Class: "video4linux", Attributes: []*AttributePredicate{{Name: "index", Equals: &zero}}},
+			paths: []string{"/dev/video0", "/dev/video2"},
+		},
+		{
+			name: "all predicates",
+			spec: &SysfsSpec{Class: "video4linux", Attributes: []*AttributePredicate{
+				{Name: "index", Equals: &zero},
+				{Name: "name", Glob: "* 046d:082d"},
+			}},
+			paths: []string{"/dev/video2"},
+		},
+		{
+			name:  "missing attribute",
+			spec:  &SysfsSpec{Class: "iio", Attributes: []*AttributePredicate{{Name: "index", Equals: &zero}}},
+			paths: nil,
+		},
+		{
+			name:  "no device nodes",
+			spec:  &SysfsSpec{Class: "net"},
+			paths: nil,
+		},
+		{
+			name:  "missing class",
+			spec:  &SysfsSpec{Class: "hidraw"},
+			paths: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.spec.validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			gp := newTestGenericPlugin(t, &DeviceSpec{Name: "video"}, sysfsFS())
+			paths, err := gp.selectSysfs(context.Background(), tc.spec)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			slices.Sort(paths)
+			if !slices.Equal(paths, tc.paths) {
+				t.Errorf("expected paths %v; got %v", tc.paths, paths)
+			}
+		})
+	}
+}
+
+func TestValidateSysfsSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec *SysfsSpec
+		err  bool
+	}{
+		{
+			name: "class",
+			spec: &SysfsSpec{Class: "hidraw", MountPath: "/dev/hid/{{.Index}}"},
+		},
+		{
+			name: "no class",
+			spec: &SysfsSpec{},
+			err:  true,
+		},
+		{
+			name: "class outside of /sys/class",
+			spec: &SysfsSpec{Class: "../bus"},
+			err:  true,
+		},
+		{
+			name: "invalid predicate",
+			spec: &SysfsSpec{Class: "hidraw", Attributes: []*AttributePredicate{{Name: "name"}}},
+			err:  true,
+		},
+		{
+			name: "malformed mount path",
+			spec: &SysfsSpec{Class: "hidraw", MountPath: "/dev/{{.Index"},
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.spec.validate(); (err != nil) != tc.err {
+				t.Errorf("expected error %t; got %v", tc.err, err)
+			}
+		})
+	}
+}
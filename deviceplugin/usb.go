@@ -15,7 +15,7 @@
 package deviceplugin
 
 import (
-	"crypto/sha1"
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -104,6 +104,25 @@ type usbDevice struct {
 	BusDevice uint16 `json:"busdev"`
 	// Serial is the serial number of the device.
 	Serial string `json:"serial"`
+	// Port is the physical port the device is attached to, i.e. the name of its sysfs directory, e.g. 1-1.2.
+	Port string `json:"port"`
+}
+
+// identity returns the identity of the device's raw device node.
+func (dev *usbDevice) identity(fsys fs.FS, lookupUdev bool) identity {
+	id := identity{
+		Path:    dev.BusPath(),
+		Serial:  dev.Serial,
+		Port:    dev.Port,
+		Vendor:  dev.Vendor.String(),
+		Product: dev.Product.String(),
+	}
+	if lookupUdev {
+		if props, err := udevProperties(fsys, "char", usbDevMajor, usbMinor(dev.Bus, dev.BusDevice)); err == nil {
+			id.IDPath = props[udevIDPath]
+		}
+	}
+	return id
 }
 
 // BusPath returns the platform-correct path to the raw device.
@@ -114,8 +133,8 @@ func (dev *usbDevice) BusPath() (path string) {
 // readFileToUint16 reads the file at the given path, then returns a representation of that file as uint16.
 // Ignores newlines.
 // Returns an error if the file could not be read, or parsed as uint16.
-func readFileToUint16(fsys fs.FS, path string) (out uint16, err error) {
-	bytes, err := fs.ReadFile(fsys, path)
+func readFileToUint16(ctx context.Context, fsys fs.FS, path string) (out uint16, err error) {
+	bytes, err := readFile(ctx, fsys, path)
 	if err != nil {
 		// We can't read this file for some reason
 		return out, err
@@ -126,7 +145,7 @@ func readFileToUint16(fsys fs.FS, path string) (out uint16, err error) {
 	// then attempt to parse as uint16.
 	dAsInt, err := strconv.ParseUint(dataStr, 16, 16)
 	if err != nil {
-		return out, fmt.Errorf("malformed device data %q: %w", dataStr, err)
+		return out, fmt.Errorf("%w in %s %q: %w", errMalformedAttribute, path, dataStr, err)
 	}
 	// Potential for overflowing, but presume we know what we're doing.
 	return uint16(dAsInt), nil
@@ -146,28 +165,32 @@ func resolveSymlinkToDir(fsys fs.FS, path string) (absolutePath string, err erro
 // queryUSBDeviceCharacteristicsByDirectory scans the given directory for information regarding the given USB device,
 // then returns a pointer to a new usbDevice if information is found.
 // Safe to presume that result is set if err is nil.
-func queryUSBDeviceCharacteristicsByDirectory(fsys fs.FS, path string) (result *usbDevice, err error) {
+// Every read gives up after discoveryReadTimeout or when the given context is done.
+func queryUSBDeviceCharacteristicsByDirectory(ctx context.Context, fsys fs.FS, path string) (result *usbDevice, err error) {
 	// Test if symlink needs to be followed.
 	path, err = resolveSymlinkToDir(fsys, path)
 
 	if err != nil {
-		return result, err
+		return result, fmt.Errorf("%w: %w", errNotUSBDevice, err)
 	}
 
 	// Try to find the vendor ID file inside this device - this is a good indication that we're dealing with a device, not a bus.
-	vnd, err := readFileToUint16(fsys, filepath.Join(path, usbDevicesDirVendorIDFile))
+	vnd, err := readFileToUint16(ctx, fsys, filepath.Join(path, usbDevicesDirVendorIDFile))
 	if err != nil {
-		// We can't read the vendor file for some reason, it probably doesn't exist.
+		if errors.Is(err, fs.ErrNotExist) {
+			// Buses and interfaces have no vendor ID.
+			return result, fmt.Errorf("%w: %w", errNotUSBDevice, err)
+		}
 		return result, err
 	}
 
-	prd, err := readFileToUint16(fsys, filepath.Join(path, usbDevicesDirProductIDFile))
+	prd, err := readFileToUint16(ctx, fsys, filepath.Join(path, usbDevicesDirProductIDFile))
 	if err != nil {
 		return result, err
 	}
 
 	serial := ""
-	serBytes, err := fs.ReadFile(fsys, filepath.Join(path, usbDevicesDirSerialFile))
+	serBytes, err := readFile(ctx, fsys, filepath.Join(path, usbDevicesDirSerialFile))
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return result, err
 	}
@@ -176,11 +199,11 @@ func queryUSBDeviceCharacteristicsByDirectory(fsys fs.FS, path string) (result *
 	}
 
 	// The following two calls shouldn't fail if the above two exist and are readable.
-	bus, err := readFileToUint16(fsys, filepath.Join(path, usbDevicesDirBusFile))
+	bus, err := readFileToUint16(ctx, fsys, filepath.Join(path, usbDevicesDirBusFile))
 	if err != nil {
 		return result, err
 	}
-	busLoc, err := readFileToUint16(fsys, filepath.Join(path, usbDevicesDirBusDevFile))
+	busLoc, err := readFileToUint16(ctx, fsys, filepath.Join(path, usbDevicesDirBusDevFile))
 	if err != nil {
 		return result, err
 	}
@@ -191,51 +214,70 @@ func queryUSBDeviceCharacteristicsByDirectory(fsys fs.FS, path string) (result *
 		Bus:       bus,
 		BusDevice: busLoc,
 		Serial:    serial,
+		Port:      filepath.Base(path),
 	}
 	return &res, nil
 }
 
 // enumerateUSBDevices rapidly scans the OS system bus for attached USB devices.
+// At most usbScanConcurrency devices are queried at the same time.
+// If the given cache is not nil, then the attributes of devices are only read
+// if their directories changed since they were last read.
+// Devices that cannot be read before the given context is done, or within
+// discoveryReadTimeout per read, are skipped and returned as diagnostics.
 // Pure Go; does not require external linking.
-func enumerateUSBDevices(fsys fs.FS, dir string) (specs []usbDevice, err error) {
-	allDevs, err := fs.ReadDir(fsys, dir)
+func enumerateUSBDevices(ctx context.Context, fsys fs.FS, dir string, cache *attributeCache) (specs []usbDevice, diagnostics []diagnostic, err error) {
+	allDevs, err := withContext(ctx, func() ([]fs.DirEntry, error) {
+		return fs.ReadDir(fsys, dir)
+	})
 	if err != nil {
-		return []usbDevice{}, err
+		return []usbDevice{}, nil, err
 	}
 
-	// Set up a WaitGroup with a buffered channel for results
+	// Every worker writes to its own index, so no further synchronization is needed.
+	results := make([]*usbDevice, len(allDevs))
+	errs := make([]error, len(allDevs))
+	sem := make(chan struct{}, usbScanConcurrency)
 	var wg sync.WaitGroup
-	devs := make(chan *usbDevice)
-
-	// You could also have a shared slice with a mutex guard, but this way is arguably a little more performant.
-	for _, dev := range allDevs {
-		// Copy the loop variable
-		dev := dev
-
-		// Spawn a goroutine to discover the device information
+	for i, dev := range allDevs {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func() {
-			defer wg.Done()
-			result, err := queryUSBDeviceCharacteristicsByDirectory(fsys, filepath.Join(dir, dev.Name()))
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			path := filepath.Join(dir, dev.Name())
+			fi, err := fs.Stat(fsys, path)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if !fi.IsDir() {
+				errs[i] = fmt.Errorf("%w: %s is not a directory", errNotUSBDevice, path)
+				return
+			}
+			result, err := cache.get(ctx, fsys, path, fi)
 			if err != nil {
-				// do we want to handle errors here?
+				errs[i] = err
 				return
 			}
-			// Successful data will get thrown onto the buffered channel for later merging
-			devs <- result
+			results[i] = result
 		}()
 	}
+	wg.Wait()
 
-	go func() {
-		defer close(devs)
-		wg.Wait()
-	}()
-
-	// Now unwind the buffer into the results array
-	for d := range devs {
-		specs = append(specs, *d)
+	for i, d := range results {
+		if d != nil {
+			specs = append(specs, *d)
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic{source: usbSource, path: filepath.Join(dir, allDevs[i].Name()), reason: skipReason(errs[i]), err: errs[i]})
 	}
-
 	return
 }
 
@@ -249,14 +291,21 @@ func searchUSBDevices(devices *[]usbDevice, vendor USBID, product USBID, serial
 	return
 }
 
-func (gp *GenericPlugin) discoverUSB() (devices []device, err error) {
-	usbDevs, err := enumerateUSBDevices(gp.fs, usbDevicesDir)
+// discoverUSB discovers the devices of all groups with USB specifications.
+// Groups that fail are skipped and their errors are returned
+// along with the devices of the other groups.
+func (gp *GenericPlugin) discoverUSB(ctx context.Context) (devices []device, err error) {
+	var errs []error
+	usbDevs, err := gp.usbDevices(ctx)
 	for _, usbDev := range usbDevs {
 		_ = level.Debug(gp.logger).Log("msg", "discovered USB device", "usbdevice", fmt.Sprintf("%v:%v", usbDev.Vendor.String(), usbDev.Product.String()), "path", usbDev.BusPath())
 	}
 
-	for _, group := range gp.ds.Groups {
+Groups:
+	for gi, group := range gp.ds.Groups {
 		var paths []string
+		var nodes []identity
+		var groupDevices []device
 		if err != nil {
 			_ = level.Warn(gp.logger).Log("msg", fmt.Sprintf("failed to enumerate usb devices: %v", err))
 			return devices, nil
@@ -264,7 +313,8 @@ func (gp *GenericPlugin) discoverUSB() (devices []device, err error) {
 		for _, dev := range group.USBSpecs {
 			matches, err := searchUSBDevices(&usbDevs, dev.Vendor, dev.Product, dev.Serial)
 			if err != nil {
-				return nil, err
+				errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+				continue Groups
 			}
 			if len(matches) == 0 {
 				_ = level.Debug(gp.logger).Log("msg", "no USB devices found attached to system")
@@ -272,12 +322,11 @@ func (gp *GenericPlugin) discoverUSB() (devices []device, err error) {
 			for _, match := range matches {
 				_ = level.Debug(gp.logger).Log("msg", "USB device match", "usbdevice", fmt.Sprintf("%v:%v", dev.Vendor.String(), dev.Product.String()), "path", match.BusPath())
 				paths = append(paths, match.BusPath())
+				nodes = append(nodes, match.identity(gp.fs, group.Identity == UdevIdentityScheme || group.IDTemplate != ""))
 			}
 		}
 		if len(paths) > 0 {
 			for j := uint(0); j < group.Count; j++ {
-				h := sha1.New()
-				h.Write([]byte(strconv.FormatUint(uint64(j), 10)))
 				d := device{
 					Device: &v1beta1.Device{
 						Health: v1beta1.Healthy,
@@ -289,12 +338,17 @@ func (gp *GenericPlugin) discoverUSB() (devices []device, err error) {
 						ContainerPath: path,
 						Permissions:   "rw",
 					})
-					h.Write([]byte(path))
 				}
-				d.ID = fmt.Sprintf("%x", h.Sum(nil))
-				devices = append(devices, d)
+				id, err := group.deviceID(j, 0, nodes)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("group %d: %w", gi, err))
+					continue Groups
+				}
+				d.ID = id
+				groupDevices = append(groupDevices, d)
 			}
 		}
+		devices = append(devices, groupDevices...)
 	}
-	return devices, nil
+	return devices, errors.Join(errs...)
 }
@@ -15,7 +15,10 @@
 package deviceplugin
 
 import (
+	"context"
 	"io/fs"
+	"maps"
+	"slices"
 	"testing"
 	"testing/fstest"
 
@@ -153,7 +156,7 @@ func TestDiscoverUSB(t *testing.T) {
 				logger: log.NewNopLogger(),
 			}
 
-			out, err := p.discoverUSB()
+			out, err := p.discoverUSB(context.Background())
 			if (err != nil) != (tc.err != nil) {
 				t.Errorf("expected error %v; got %v", tc.err, err)
 			}
@@ -186,3 +189,70 @@ func TestDiscoverUSB(t *testing.T) {
 		})
 	}
 }
+
+// deniedFS denies opening the given files.
+type deniedFS struct {
+	fs.FS
+	denied map[string]bool
+}
+
+func (d deniedFS) Open(name string) (fs.File, error) {
+	if d.denied[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return d.FS.Open(name)
+}
+
+func TestEnumerateUSBDevicesSkipReasons(t *testing.T) {
+	fsys := deniedFS{
+		FS: fstest.MapFS{
+			// A device.
+			"sys/bus/usb/devices/1-1/idVendor":  {Data: []byte("1050\n")},
+			"sys/bus/usb/devices/1-1/idProduct": {Data: []byte("0407\n")},
+			"sys/bus/usb/devices/1-1/busnum":    {Data: []byte("1\n")},
+			"sys/bus/usb/devices/1-1/devnum":    {Data: []byte("2\n")},
+			// An interface.
+			"sys/bus/usb/devices/1-1:1.0/bInterfaceClass": {Data: []byte("03\n")},
+			// Not a directory.
+			"sys/bus/usb/devices/file": {},
+			// A device without a bus number.
+			"sys/bus/usb/devices/1-2/idVendor":  {Data: []byte("1050\n")},
+			"sys/bus/usb/devices/1-2/idProduct": {Data: []byte("0407\n")},
+			"sys/bus/usb/devices/1-2/devnum":    {Data: []byte("3\n")},
+			// A device with a malformed product ID.
+			"sys/bus/usb/devices/1-3/idVendor":  {Data: []byte("1050\n")},
+			"sys/bus/usb/devices/1-3/idProduct": {Data: []byte("zzzz\n")},
+			// A device whose vendor ID may not be read.
+			"sys/bus/usb/devices/1-4/idVendor": {Data: []byte("1050\n")},
+		},
+		denied: map[string]bool{"sys/bus/usb/devices/1-4/idVendor": true},
+	}
+	devs, diagnostics, err := enumerateUSBDevices(context.Background(), absolute.New(fsys, "/"), usbDevicesDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devs) != 1 || devs[0].Port != "1-1" {
+		t.Errorf("expected device 1-1; got %v", devs)
+	}
+	reasons := make(map[string]SkipReason)
+	for _, d := range diagnostics {
+		if d.source != usbSource {
+			t.Errorf("expected source %q; got %q", usbSource, d.source)
+		}
+		reasons[d.path] = d.reason
+	}
+	for path, reason := range map[string]SkipReason{
+		"/sys/bus/usb/devices/1-1:1.0": NotDeviceSkipReason,
+		"/sys/bus/usb/devices/file":    NotDeviceSkipReason,
+		"/sys/bus/usb/devices/1-2":     UnreadableSkipReason,
+		"/sys/bus/usb/devices/1-3":     MalformedSkipReason,
+		"/sys/bus/usb/devices/1-4":     UnreadableSkipReason,
+	} {
+		if reasons[path] != reason {
+			t.Errorf("%s: expected reason %q; got %q", path, reason, reasons[path])
+		}
+	}
+	if len(reasons) != 5 {
+		t.Errorf("expected 5 skipped entries; got %v", slices.Sorted(maps.Keys(reasons)))
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2026 the generic-device-plugin authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deviceplugin
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// The reasons for restarting a plugin.
+const (
+	restartReasonError          = "error"
+	restartReasonSocketRemoved  = "socket-removed"
+	restartReasonKubeletRestart = "kubelet-restart"
+)
+
+// restartError signals that the plugin must be restarted immediately for the given reason.
+type restartError struct {
+	reason string
+	err    error
+}
+
+func (e *restartError) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason, e.err)
+}
+
+func (e *restartError) Unwrap() error {
+	return e.err
+}
+
+// socketWatcher turns file-system notifications about the plugin socket
+// and the kubelet socket into restart reasons.
+type socketWatcher struct {
+	watcher *fsnotify.Watcher
+	// events receives a restart reason for every relevant notification.
+	events chan string
+	// handoff receives a value whenever the handoff marker is written.
+	handoff chan struct{}
+	done    chan struct{}
+}
+
+// newSocketWatcher watches for the removal of the plugin socket,
+// for writes to the handoff marker next to it, and,
+// if kubeletSocket is not empty, for the creation of the kubelet socket.
+func newSocketWatcher(socket, kubeletSocket, handoffMarker string, logger log.Logger) (*socketWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file-system watcher: %w", err)
+	}
+	dirs := map[string]struct{}{filepath.Dir(socket): {}}
+	if kubeletSocket != "" {
+		dirs[filepath.Dir(kubeletSocket)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			_ = w.Close()
+			return nil, fmt.Errorf("failed to watch %q: %w", dir, err)
+		}
+	}
+	sw := &socketWatcher{
+		watcher: w,
+		events:  make(chan string, 1),
+		handoff: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(sw.done)
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if e.Name == handoffMarker && (e.Has(fsnotify.Create) || e.Has(fsnotify.Write)) {
+					select {
+					case sw.handoff <- struct{}{}:
+					default:
+					}
+					continue
+				}
+				var reason string
+				switch {
+				case e.Name == socket && (e.Has(fsnotify.Remove) || e.Has(fsnotify.Rename)):
+					reason = restartReasonSocketRemoved
+				case kubeletSocket != "" && e.Name == kubeletSocket && e.Has(fsnotify.Create):
+					reason = restartReasonKubeletRestart
+				default:
+					continue
+				}
+				select {
+				case sw.events <- reason:
+				default:
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				_ = level.Warn(logger).Log("msg", "encountered error while watching sockets", "err", err)
+			}
+		}
+	}()
+	return sw, nil
+}
+
+// Close stops watching.
+func (sw *socketWatcher) Close() error {
+	err := sw.watcher.Close()
+	<-sw.done
+	return err
+}
+
+// reasons returns the channel of restart reasons.
+// It is safe to call on a nil socketWatcher, in which case the channel never receives.
+func (sw *socketWatcher) reasons() <-chan string {
+	if sw == nil {
+		return nil
+	}
+	return sw.events
+}
+
+// handoffs returns the channel of writes to the handoff marker.
+// It is safe to call on a nil socketWatcher, in which case the channel never receives.
+func (sw *socketWatcher) handoffs() <-chan struct{} {
+	if sw == nil {
+		return nil
+	}
+	return sw.handoff
+}
@@ -16,17 +16,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -59,6 +64,20 @@ var (
 		logLevelError,
 		logLevelNone,
 	}, ", ")
+	availableRegistrationModes = func() string {
+		modes := make([]string, 0, len(deviceplugin.RegistrationModes))
+		for _, m := range deviceplugin.RegistrationModes {
+			modes = append(modes, string(m))
+		}
+		return strings.Join(modes, ", ")
+	}()
+	availableOverlapPolicies = func() string {
+		policies := make([]string, 0, len(deviceplugin.OverlapPolicies))
+		for _, p := range deviceplugin.OverlapPolicies {
+			policies = append(policies, string(p))
+		}
+		return strings.Join(policies, ", ")
+	}()
 )
 
 func testUSBFunctionalityAvailableOnThisPlatform() (err error) {
@@ -115,12 +134,51 @@ func Main() error {
 				deviceSpecs[i].Groups[j].Paths[k].Path = strings.TrimSpace(deviceSpecs[i].Groups[j].Paths[k].Path)
 				deviceSpecs[i].Groups[j].Paths[k].MountPath = strings.TrimSpace(deviceSpecs[i].Groups[j].Paths[k].MountPath)
 			}
+			for k := range deviceSpecs[i].Groups[j].Sysfs {
+				deviceSpecs[i].Groups[j].Sysfs[k].MountPath = strings.TrimSpace(deviceSpecs[i].Groups[j].Sysfs[k].MountPath)
+			}
+		}
+		if err := deviceSpecs[i].Validate(); err != nil {
+			return fmt.Errorf("failed to parse device %q: %w", dsr.Name, err)
 		}
 	}
 	if len(deviceSpecs) == 0 {
 		return fmt.Errorf("at least one device must be specified")
 	}
 
+	hostRoot := viper.GetString("host-root")
+	if fi, err := os.Stat(hostRoot); err != nil {
+		return fmt.Errorf("failed to find host root %q: %w", hostRoot, err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("host root %q is not a directory", hostRoot)
+	}
+
+	overlapPolicy := deviceplugin.OverlapPolicy(viper.GetString("overlap-policy"))
+	if !slices.Contains(deviceplugin.OverlapPolicies, overlapPolicy) {
+		return fmt.Errorf("overlap policy %v unknown; possible values are: %s", overlapPolicy, availableOverlapPolicies)
+	}
+
+	var pluginOptions []deviceplugin.Option
+	mode := deviceplugin.RegistrationMode(viper.GetString("registration-mode"))
+	switch mode {
+	case deviceplugin.RegisterRegistrationMode:
+	case deviceplugin.PluginWatcherRegistrationMode:
+		pluginOptions = append(pluginOptions, deviceplugin.WithPluginWatcher(viper.GetString("plugins-registry-directory")))
+	default:
+		return fmt.Errorf("registration mode %v unknown; possible values are: %s", mode, availableRegistrationModes)
+	}
+
+	drainGracePeriod := viper.GetDuration("drain-grace-period")
+	if drainGracePeriod < 0 {
+		return fmt.Errorf("drain grace period %s must not be negative", drainGracePeriod)
+	}
+	pluginOptions = append(pluginOptions, deviceplugin.WithDrainGracePeriod(drainGracePeriod))
+
+	livenessThreshold := viper.GetDuration("liveness-threshold")
+	if livenessThreshold < 0 {
+		return fmt.Errorf("liveness threshold %s must not be negative", livenessThreshold)
+	}
+
 	if shouldTestUSBAvailable {
 		err := testUSBFunctionalityAvailableOnThisPlatform()
 		if err != nil {
@@ -155,6 +213,9 @@ func Main() error {
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 
+	engine := deviceplugin.NewDiscoveryEngine(hostRoot, log.With(logger, "component", "discovery"), r)
+	// plugins holds every plugin by resource name; it is filled before any request is served.
+	plugins := make(map[string]deviceplugin.Plugin, len(deviceSpecs))
 	var g run.Group
 	{
 		// Run the HTTP server.
@@ -162,7 +223,14 @@ func Main() error {
 		mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
 			w.WriteHeader(http.StatusOK)
 		})
+		mux.HandleFunc("/livez", stateHandler(plugins, func(p deviceplugin.Plugin) bool {
+			return alive(p, livenessThreshold, time.Now())
+		}))
+		mux.HandleFunc("/readyz", stateHandler(plugins, func(p deviceplugin.Plugin) bool {
+			return p.State() == deviceplugin.StateRegistered
+		}))
 		mux.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+		mux.HandleFunc("/debug/discovery/skipped", skippedHandler(engine))
 		listen := viper.GetString("listen")
 		l, err := net.Listen("tcp", listen)
 		if err != nil {
@@ -199,7 +267,53 @@ func Main() error {
 		})
 	}
 
+	pluginOptions = append(pluginOptions, deviceplugin.WithDiscoveryEngine(engine))
+	{
+		// Scan all discovery sources once per interval for all plugins.
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return engine.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	claims := deviceplugin.NewClaimRegistry(overlapPolicy, viper.GetString("pod-resources-socket"), log.With(logger, "component", "claims"))
+	{
+		// Release the claims of devices that are no longer in use.
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return claims.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	pluginPath := viper.GetString("plugin-directory")
+	if pluginPath == pluginDirectoryAuto {
+		if pluginPath, err = deviceplugin.DetectPluginDir(deviceplugin.KnownPluginDirs); err != nil {
+			return fmt.Errorf("failed to detect plugin directory: %w", err)
+		}
+		_ = logger.Log("msg", "detected plugin directory", "dir", pluginPath)
+	}
+	{
+		// Treat the devices in the kubelet's checkpoint as allocated.
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return claims.WatchCheckpoint(ctx, filepath.Join(pluginPath, deviceplugin.KubeletCheckpoint))
+		}, func(error) {
+			cancel()
+		})
+	}
+	if kubeletSocket := viper.GetString("kubelet-socket"); kubeletSocket != "" {
+		pluginOptions = append(pluginOptions, deviceplugin.WithKubeletSocket(kubeletSocket))
+	}
+	// Remove the sockets that earlier instances left behind once, before any plugin creates its socket.
+	socketDir := pluginPath
+	if mode == deviceplugin.PluginWatcherRegistrationMode {
+		socketDir = viper.GetString("plugins-registry-directory")
+	}
+	deviceplugin.CollectGarbage(socketDir, log.With(logger, "component", "garbage-collector"))
 	for i := range deviceSpecs {
 		d := deviceSpecs[i]
 
@@ -212,7 +326,8 @@ func Main() error {
 		}
 
 		ctx, cancel := context.WithCancel(context.Background())
-		gp := deviceplugin.NewGenericPlugin(d, pluginPath, log.With(logger, "resource", d.Name), prometheus.WrapRegistererWith(prometheus.Labels{"resource": d.Name}, r), enableUSBDiscovery)
+		gp := deviceplugin.NewGenericPlugin(d, pluginPath, log.With(logger, "resource", d.Name), prometheus.WrapRegistererWith(prometheus.Labels{"resource": d.Name}, r), enableUSBDiscovery, claims, hostRoot, pluginOptions...)
+		plugins[d.Name] = gp
 		// Start the generic device plugin server.
 		g.Add(func() error {
 			_ = logger.Log("msg", fmt.Sprintf("Starting the generic-device-plugin for %q.", d.Name))
@@ -225,6 +340,39 @@ func Main() error {
 	return g.Run()
 }
 
+// stateHandler reports the state of every plugin.
+// It responds with 503 unless the given function returns true for all plugins.
+func stateHandler(plugins map[string]deviceplugin.Plugin, ok func(deviceplugin.Plugin) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		code := http.StatusOK
+		var b strings.Builder
+		for _, name := range slices.Sorted(maps.Keys(plugins)) {
+			if !ok(plugins[name]) {
+				code = http.StatusServiceUnavailable
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, plugins[name].State())
+		}
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(b.String()))
+	}
+}
+
+// alive returns false if the given plugin has failed to register with the kubelet
+// for longer than the given threshold at the given time.
+// A threshold of 0 means that plugins are always alive.
+func alive(p deviceplugin.Plugin, threshold time.Duration, now time.Time) bool {
+	since := p.FailingSince()
+	return threshold == 0 || since.IsZero() || now.Sub(since) <= threshold
+}
+
+// skippedHandler reports the entries of discovery sources that were skipped most recently as JSON.
+func skippedHandler(engine *deviceplugin.DiscoveryEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(engine.Skipped())
+	}
+}
+
 func main() {
 	if err := Main(); err != nil {
 		fmt.Fprintf(os.Stderr, "Execution failed: %v\n", err)